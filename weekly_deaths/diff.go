@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"weekly_deaths/eurostat"
+)
+
+// runDiffCommand compares two local snapshot files and writes
+// the differences as JSON. Usage: diff [-summary] <old.tsv.gz> <new.tsv.gz>.
+// Nothing is written next to the files (see eurostat.ParseSnapshotFile).
+func runDiffCommand(args []string, w io.Writer) error {
+	var summary bool
+
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.BoolVar(&summary, "summary", false, "print only numbers of differences")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return errors.New("usage: diff [-summary] <old.tsv.gz> <new.tsv.gz>")
+	}
+
+	old, err := eurostat.ParseSnapshotFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fs.Arg(0), err)
+	}
+
+	new, err := eurostat.ParseSnapshotFile(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fs.Arg(1), err)
+	}
+
+	diff, err := eurostat.DiffSnapshots(old, new)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if summary {
+		return encoder.Encode(diff.Summary())
+	}
+	return encoder.Encode(diff)
+}
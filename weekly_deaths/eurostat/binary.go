@@ -0,0 +1,218 @@
+package eurostat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Binary snapshot format (all integers are little endian):
+//
+//	magic       [6]byte  "WDSNAP"
+//	version     uint16
+//	source hash [32]byte SHA-256 of the raw .tsv.gz file snapshot was parsed from
+//	timestamp   int64    Unix nanoseconds
+//	series      uint32   number of series, followed by each series:
+//	  key length  uint16, key bytes (see makeKey)
+//	  values      uint16   number of values, followed by each value:
+//	    week uint8, deaths uint32, flags uint8
+//	checksum    uint32   CRC-32 (Castagnoli) of all preceding bytes
+const (
+	binarySnapshotMagic   = "WDSNAP"
+	binarySnapshotVersion = 1
+
+	binarySnapshotExtension = ".snap"
+)
+
+var (
+	ErrBadSnapshotFormat          = errors.New("not a binary snapshot")
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported binary snapshot version")
+	ErrSnapshotChecksumMismatch   = errors.New("binary snapshot checksum mismatch")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SourceHash returns the hash identifying raw snapshot data.
+func SourceHash(raw []byte) [sha256.Size]byte {
+	return sha256.Sum256(raw)
+}
+
+// EncodeSnapshot writes the snapshot in binary format.
+func EncodeSnapshot(w io.Writer, snapshot DataSnapshot, sourceHash [sha256.Size]byte) error {
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	keys := make([]string, 0, len(snapshot.Data))
+	for k := range snapshot.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, binarySnapshotMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, binarySnapshotVersion)
+	buf = append(buf, sourceHash[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(snapshot.Timestamp.UnixNano()))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(keys)))
+	_, _ = bw.Write(buf)
+
+	for _, k := range keys {
+		values := snapshot.Data[k]
+		if len(k) > math.MaxUint16 || len(values) > math.MaxUint16 {
+			return fmt.Errorf("series %s too large for binary snapshot", k)
+		}
+
+		buf = binary.LittleEndian.AppendUint16(buf[:0], uint16(len(k)))
+		buf = append(buf, k...)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(values)))
+		for _, v := range values {
+			buf = append(buf, v.Week)
+			buf = binary.LittleEndian.AppendUint32(buf, v.Deaths)
+			buf = append(buf, v.Flags)
+		}
+		_, _ = bw.Write(buf)
+	}
+
+	err := bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, crc.Sum32())
+}
+
+// byteReader reads little endian values from a byte slice,
+// remembering the first out of bounds read.
+type byteReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *byteReader) next(n int) []byte {
+	if r.err != nil || r.off+n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	v := r.b[r.off : r.off+n]
+	r.off += n
+	return v
+}
+
+func (r *byteReader) uint8() uint8   { return r.next(1)[0] }
+func (r *byteReader) uint16() uint16 { return binary.LittleEndian.Uint16(r.next(2)) }
+func (r *byteReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.next(4)) }
+func (r *byteReader) uint64() uint64 { return binary.LittleEndian.Uint64(r.next(8)) }
+
+// DecodeSnapshot reads a snapshot written by EncodeSnapshot
+// along with the hash of its source.
+func DecodeSnapshot(r io.Reader) (DataSnapshot, [sha256.Size]byte, error) {
+	var (
+		ds         DataSnapshot
+		sourceHash [sha256.Size]byte
+	)
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return ds, sourceHash, err
+	}
+
+	if len(b) < len(binarySnapshotMagic)+2+4 || string(b[:len(binarySnapshotMagic)]) != binarySnapshotMagic {
+		return ds, sourceHash, ErrBadSnapshotFormat
+	}
+
+	if v := binary.LittleEndian.Uint16(b[len(binarySnapshotMagic):]); v != binarySnapshotVersion {
+		return ds, sourceHash, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, v)
+	}
+
+	payload, checksum := b[:len(b)-4], binary.LittleEndian.Uint32(b[len(b)-4:])
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return ds, sourceHash, ErrSnapshotChecksumMismatch
+	}
+
+	br := &byteReader{b: payload, off: len(binarySnapshotMagic) + 2}
+	copy(sourceHash[:], br.next(sha256.Size))
+	ds.Timestamp = time.Unix(0, int64(br.uint64())).UTC()
+	ds.SourceHash = sourceHash
+
+	series := br.uint32()
+	if br.err != nil {
+		return ds, sourceHash, fmt.Errorf("%w: %s", ErrBadSnapshotFormat, br.err)
+	}
+
+	ds.Data = make(map[string][]WeeklyDeaths, series)
+	for i := uint32(0); i < series; i++ {
+		key := string(br.next(int(br.uint16())))
+		values := make([]WeeklyDeaths, br.uint16())
+		for j := range values {
+			values[j] = WeeklyDeaths{Week: br.uint8(), Deaths: br.uint32(), Flags: br.uint8()}
+		}
+		if br.err != nil {
+			return ds, sourceHash, fmt.Errorf("%w: %s", ErrBadSnapshotFormat, br.err)
+		}
+
+		ds.Data[key] = values
+	}
+
+	return ds, sourceHash, nil
+}
+
+// binarySnapshotPath returns path of the binary cache
+// kept next to the raw snapshot file.
+func binarySnapshotPath(rawPath string) string {
+	return strings.TrimSuffix(rawPath, dataFileExtension) + binarySnapshotExtension
+}
+
+// readBinarySnapshot loads snapshot from the binary cache file,
+// provided it was created from the raw data with given hash.
+func readBinarySnapshot(path string, sourceHash [sha256.Size]byte) (DataSnapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DataSnapshot{}, err
+	}
+	defer file.Close()
+
+	ds, hash, err := DecodeSnapshot(file)
+	if err != nil {
+		return ds, err
+	}
+
+	if hash != sourceHash {
+		return DataSnapshot{}, errors.New("binary snapshot was created from different raw data")
+	}
+
+	return ds, nil
+}
+
+// writeBinarySnapshot writes the binary cache file. Data is written
+// to a temporary file first, so a partially written cache is never read.
+func writeBinarySnapshot(path string, snapshot DataSnapshot, sourceHash [sha256.Size]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = EncodeSnapshot(tmp, snapshot, sourceHash)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,171 @@
+package eurostat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecodeSnapshot(t *testing.T) {
+	snapshot := conformanceSnapshot()
+	hash := SourceHash([]byte("raw"))
+
+	var buf bytes.Buffer
+	err := EncodeSnapshot(&buf, snapshot, hash)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+
+	got, gotHash, err := DecodeSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	if gotHash != hash {
+		t.Fatalf("wanted source hash %x but got %x", hash, gotHash)
+	}
+	if !got.Timestamp.Equal(snapshot.Timestamp) {
+		t.Fatalf("wanted timestamp %s but got %s", snapshot.Timestamp, got.Timestamp)
+	}
+	if !reflect.DeepEqual(got.Data, snapshot.Data) {
+		t.Fatalf("wanted %+v but got %+v", snapshot.Data, got.Data)
+	}
+}
+
+func TestDecodeSnapshotErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := EncodeSnapshot(&buf, conformanceSnapshot(), SourceHash(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	corrupt := func(fn func(b []byte)) []byte {
+		b := append([]byte(nil), encoded...)
+		fn(b)
+		return b
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "empty", data: nil, want: ErrBadSnapshotFormat},
+		{name: "bad magic", data: corrupt(func(b []byte) { b[0] = 'X' }), want: ErrBadSnapshotFormat},
+		{name: "unknown version", data: corrupt(func(b []byte) { b[len(binarySnapshotMagic)] = 99 }), want: ErrUnsupportedSnapshotVersion},
+		{name: "flipped value", data: corrupt(func(b []byte) { b[len(b)-10] ^= 0xff }), want: ErrSnapshotChecksumMismatch},
+		{name: "truncated", data: encoded[:len(encoded)-20], want: ErrSnapshotChecksumMismatch},
+	}
+
+	for _, c := range cases {
+		_, _, err := DecodeSnapshot(bytes.NewReader(c.data))
+		if !errors.Is(err, c.want) {
+			t.Fatalf("%s: wanted %v but got %v", c.name, c.want, err)
+		}
+	}
+}
+
+func writeRawSnapshot(t *testing.T, path string, tsv string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(tsv))
+	w.Close()
+
+	err := os.WriteFile(path, buf.Bytes(), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataSnapshotFromPathUsesBinaryCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "20230102T030405.tsv.gz")
+	writeRawSnapshot(t, path, `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	123	100`)
+
+	ds, err := DataSnapshotFromPath(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+
+	cachePath := filepath.Join(dir, "20230102T030405.snap")
+	cached, hash, err := func() (DataSnapshot, [32]byte, error) {
+		f, err := os.Open(cachePath)
+		if err != nil {
+			t.Fatalf("Expected binary cache to be written: %s", err)
+		}
+		defer f.Close()
+		return DecodeSnapshot(f)
+	}()
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	if !reflect.DeepEqual(cached.Data, ds.Data) {
+		t.Fatalf("wanted cached data %+v but got %+v", ds.Data, cached.Data)
+	}
+
+	// cache matching the raw file is used instead of parsing it
+	marker := DataSnapshot{
+		Data:      map[string][]WeeklyDeaths{"PL|2021|TOTAL|T": {{Week: 1, Deaths: 999}}},
+		Timestamp: ds.Timestamp,
+	}
+	err = writeBinarySnapshot(cachePath, marker, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DataSnapshotFromPath(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	if !reflect.DeepEqual(got.Data, marker.Data) {
+		t.Fatalf("wanted data from cache %+v but got %+v", marker.Data, got.Data)
+	}
+
+	// cache is ignored and rewritten once the raw file changes
+	writeRawSnapshot(t, path, `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	200	100`)
+
+	got, err = DataSnapshotFromPath(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	want := []WeeklyDeaths{{Week: 1, Deaths: 100}, {Week: 2, Deaths: 200}}
+	if !reflect.DeepEqual(got.Data["PL|2021|TOTAL|T"], want) {
+		t.Fatalf("wanted %+v but got %+v", want, got.Data["PL|2021|TOTAL|T"])
+	}
+	if want := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC); !got.Timestamp.Equal(want) {
+		t.Fatalf("wanted timestamp %s but got %s", want, got.Timestamp)
+	}
+}
+
+func TestParseSnapshotFileDoesNotWriteCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "20230102T030405.tsv.gz")
+	writeRawSnapshot(t, path, `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	123	100`)
+
+	ds, err := ParseSnapshotFile(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	want := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !ds.Timestamp.Equal(want) || len(ds.Data["PL|2021|TOTAL|T"]) != 2 {
+		t.Fatalf("unexpected snapshot %+v", ds)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected no files to be written next to the snapshot but got %v", entries)
+	}
+}
@@ -0,0 +1,128 @@
+package eurostat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrPreconditionFailed            = errors.New("object was modified concurrently")
+	ErrConditionalWritesNotSupported = errors.New("snapshot store doesn't support conditional writes")
+	errDirLockTimeout                = errors.New("timeout waiting for object lock")
+)
+
+// ConditionalSnapshotStore is implemented by stores supporting conditional
+// writes (compare-and-swap of a single object), used for leases.
+type ConditionalSnapshotStore interface {
+	SnapshotStore
+	// GetWithVersion returns content of the object along with its version.
+	GetWithVersion(key string) ([]byte, string, error)
+	// PutIf replaces the object only if its version is still the given one
+	// (empty version - only if the object doesn't exist) and returns the new
+	// version. ErrPreconditionFailed is returned otherwise.
+	PutIf(key string, data []byte, version string) (string, error)
+}
+
+// contentVersion is the version of objects in stores without native versions.
+func contentVersion(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:16])
+}
+
+func (s *MemorySnapshotStore) GetWithVersion(key string) ([]byte, string, error) {
+	data, err := s.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+func (s *MemorySnapshotStore) PutIf(key string, data []byte, version string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.objects[key]
+	if ok != (version != "") || ok && contentVersion(current) != version {
+		return "", fmt.Errorf("%w: %s", ErrPreconditionFailed, key)
+	}
+
+	s.objects[key] = append([]byte(nil), data...)
+	s.metadata[key] = make(map[string]string)
+	return contentVersion(data), nil
+}
+
+// Lock files guarding conditional writes of DirSnapshotStore, so that
+// they're safe across processes sharing the directory. Locks left
+// by crashed processes are removed once they're stale.
+const (
+	dirLockPrefix  = ".lock."
+	dirLockTimeout = 10 * time.Second
+	dirLockStale   = 30 * time.Second
+)
+
+func (s *DirSnapshotStore) lock(key string) (func(), error) {
+	path := filepath.Join(s.dir, dirLockPrefix+key)
+	deadline := time.Now().Add(dirLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > dirLockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", errDirLockTimeout, key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *DirSnapshotStore) GetWithVersion(key string) ([]byte, string, error) {
+	data, err := s.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+func (s *DirSnapshotStore) PutIf(key string, data []byte, version string) (string, error) {
+	_, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := s.lock(key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	current, err := s.Get(key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrSnapshotObjectNotFound) {
+		return "", err
+	}
+	if exists != (version != "") || exists && contentVersion(current) != version {
+		return "", fmt.Errorf("%w: %s", ErrPreconditionFailed, key)
+	}
+
+	err = s.Put(key, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return contentVersion(data), nil
+}
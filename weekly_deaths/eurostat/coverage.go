@@ -0,0 +1,195 @@
+package eurostat
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Coverage describes data completeness of a single series
+// (or country, when computed for the totals series).
+type Coverage struct {
+	Country          string   `json:"country"`
+	Age              string   `json:"age"`
+	Gender           string   `json:"gender"`
+	FirstWeek        string   `json:"first_reported_week"`
+	LastWeek         string   `json:"last_reported_week"`
+	MissingWeeks     []string `json:"missing_weeks"`
+	ReportedWeeks    int      `json:"reported_weeks"`
+	ProvisionalShare float64  `json:"provisional_share"`
+	LagWeeks         int      `json:"lag_weeks"`
+}
+
+// CoverageFilter narrows down series coverage is computed for.
+// Empty fields match all values.
+type CoverageFilter struct {
+	Country string
+	Age     string
+	Gender  string
+}
+
+func (f CoverageFilter) matches(m Metadata) bool {
+	return (f.Country == "" || f.Country == m.Country) &&
+		(f.Age == "" || f.Age == m.Age) &&
+		(f.Gender == "" || f.Gender == m.Gender)
+}
+
+func (woy WeekOfYear) String() string {
+	return fmt.Sprintf("%dW%02d", woy.Year, woy.Week)
+}
+
+// ComputeCoverage returns the first and last reported week, missing weeks
+// between them, share of provisional values and reporting lag (in weeks,
+// relative to the snapshot timestamp) for every series matching the filter.
+// Series without any reported value are skipped.
+func ComputeCoverage(snapshot DataSnapshot, filter CoverageFilter) ([]Coverage, error) {
+	type seriesWeeks map[WeekOfYear]WeeklyDeaths
+
+	series := make(map[Metadata]seriesWeeks)
+	for key, values := range snapshot.Data {
+		metadata, year, err := parseKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !filter.matches(metadata) {
+			continue
+		}
+
+		if _, ok := series[metadata]; !ok {
+			series[metadata] = make(seriesWeeks)
+		}
+
+		for _, v := range values {
+			if v.Flags&FlagMissing != 0 {
+				continue
+			}
+			series[metadata][WeekOfYear{Year: year, Week: int(v.Week)}] = v
+		}
+	}
+
+	res := make([]Coverage, 0, len(series))
+	for metadata, weeks := range series {
+		if len(weeks) == 0 {
+			continue
+		}
+
+		reported := make([]WeekOfYear, 0, len(weeks))
+		provisional := 0
+		for woy, v := range weeks {
+			reported = append(reported, woy)
+			if v.Flags&FlagProvisional != 0 {
+				provisional++
+			}
+		}
+		sort.Slice(reported, func(i, j int) bool {
+			return weekOfYearBefore(reported[i], reported[j])
+		})
+
+		first, last := reported[0], reported[len(reported)-1]
+		missing := make([]string, 0)
+		for woy := first; weekOfYearBefore(woy, last); woy = nextWeekOfYear(woy) {
+			if _, ok := weeks[woy]; !ok {
+				missing = append(missing, woy.String())
+			}
+		}
+
+		res = append(res, Coverage{
+			Country:          metadata.Country,
+			Age:              metadata.Age,
+			Gender:           metadata.Gender,
+			FirstWeek:        first.String(),
+			LastWeek:         last.String(),
+			MissingWeeks:     missing,
+			ReportedWeeks:    len(reported),
+			ProvisionalShare: float64(provisional) / float64(len(reported)),
+			LagWeeks:         weekLag(last.Year, last.Week, snapshot.Timestamp),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Country != res[j].Country {
+			return res[i].Country < res[j].Country
+		}
+		if res[i].Age != res[j].Age {
+			return res[i].Age < res[j].Age
+		}
+		return res[i].Gender < res[j].Gender
+	})
+
+	return res, nil
+}
+
+// CoverageCache keeps coverage of all series of the snapshot it was last
+// computed for, so that it's computed once per snapshot rather than on
+// every request.
+type CoverageCache struct {
+	mu        sync.Mutex
+	timestamp time.Time
+	coverage  []Coverage
+}
+
+func NewCoverageCache() *CoverageCache {
+	return &CoverageCache{}
+}
+
+// Coverage returns coverage of series matching the filter (see ComputeCoverage)
+// along with the timestamp of the snapshot it was computed for. The snapshot
+// is loaded only if coverage of the one with given timestamp isn't cached.
+// Nil cache computes coverage on every call.
+func (c *CoverageCache) Coverage(timestamp time.Time, load func() (DataSnapshot, error), filter CoverageFilter) ([]Coverage, time.Time, error) {
+	if c != nil {
+		c.mu.Lock()
+		cached, ok := c.coverage, c.coverage != nil && c.timestamp.Equal(timestamp)
+		c.mu.Unlock()
+
+		if ok {
+			return filterCoverage(cached, filter), timestamp, nil
+		}
+	}
+
+	snapshot, err := load()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	coverage, err := ComputeCoverage(snapshot, CoverageFilter{})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if c != nil {
+		c.mu.Lock()
+		c.timestamp, c.coverage = snapshot.Timestamp, coverage
+		c.mu.Unlock()
+	}
+
+	return filterCoverage(coverage, filter), snapshot.Timestamp, nil
+}
+
+func filterCoverage(coverage []Coverage, filter CoverageFilter) []Coverage {
+	res := make([]Coverage, 0)
+	for _, c := range coverage {
+		if filter.matches(Metadata{Country: c.Country, Age: c.Age, Gender: c.Gender}) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func weekOfYearBefore(a WeekOfYear, b WeekOfYear) bool {
+	if a.Year != b.Year {
+		return a.Year < b.Year
+	}
+	return a.Week < b.Week
+}
+
+// nextWeekOfYear returns the week following woy. Parser skips
+// week 53 (see parseLine), so years are assumed to have 52 weeks.
+func nextWeekOfYear(woy WeekOfYear) WeekOfYear {
+	if woy.Week >= maxIsoWeekNum-1 {
+		return WeekOfYear{Year: woy.Year + 1, Week: 1}
+	}
+	return WeekOfYear{Year: woy.Year, Week: woy.Week + 1}
+}
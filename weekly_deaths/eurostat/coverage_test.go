@@ -0,0 +1,103 @@
+package eurostat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestComputeCoverage(t *testing.T) {
+	snapshot := DataSnapshot{
+		Data: map[string][]WeeklyDeaths{
+			"PL|2020|TOTAL|T": {
+				{Week: 51, Deaths: 10},
+				{Week: 52, Deaths: 0, Flags: FlagMissing},
+			},
+			"PL|2021|TOTAL|T": {
+				{Week: 1, Deaths: 12},
+				{Week: 2, Deaths: 14, Flags: FlagProvisional},
+				{Week: 3, Deaths: 0, Flags: FlagMissing},
+			},
+			"PL|2021|TOTAL|F": {
+				{Week: 1, Deaths: 6},
+			},
+			"DE|2021|TOTAL|T": {
+				{Week: 1, Deaths: 0, Flags: FlagMissing},
+			},
+		},
+		Timestamp: time.Date(2021, 1, 27, 0, 0, 0, 0, time.UTC),
+	}
+
+	got, err := ComputeCoverage(snapshot, CoverageFilter{Age: "TOTAL", Gender: "T"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Coverage{
+		{
+			Country:          "PL",
+			Age:              "TOTAL",
+			Gender:           "T",
+			FirstWeek:        "2020W51",
+			LastWeek:         "2021W02",
+			MissingWeeks:     []string{"2020W52"},
+			ReportedWeeks:    3,
+			ProvisionalShare: 1.0 / 3,
+			LagWeeks:         2,
+		},
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+
+	got, err = ComputeCoverage(snapshot, CoverageFilter{Country: "PL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Gender != "F" || got[1].Gender != "T" {
+		t.Fatalf("expected coverage of two PL series but got %+v", got)
+	}
+}
+
+func TestCoverageCache(t *testing.T) {
+	ts := time.Date(2021, 1, 27, 0, 0, 0, 0, time.UTC)
+	loads := 0
+	load := func(deaths uint32) func() (DataSnapshot, error) {
+		return func() (DataSnapshot, error) {
+			loads++
+			return DataSnapshot{
+				Data: map[string][]WeeklyDeaths{
+					"PL|2021|TOTAL|T": {{Week: 1, Deaths: deaths}},
+					"DE|2021|TOTAL|T": {{Week: 1, Deaths: deaths}, {Week: 2, Deaths: deaths}},
+				},
+				Timestamp: ts.Add(time.Duration(deaths) * time.Hour),
+			}, nil
+		}
+	}
+
+	cache := NewCoverageCache()
+	for i := 0; i < 2; i++ {
+		got, gotTs, err := cache.Coverage(ts, load(0), CoverageFilter{Country: "DE"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Country != "DE" || got[0].ReportedWeeks != 2 || !gotTs.Equal(ts) {
+			t.Fatalf("unexpected coverage %+v of %s", got, gotTs)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected snapshot to be loaded once but got %d loads", loads)
+	}
+
+	// coverage is computed again for another snapshot
+	newer := ts.Add(time.Hour)
+	got, gotTs, err := cache.Coverage(newer, load(1), CoverageFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !gotTs.Equal(newer) || loads != 2 {
+		t.Fatalf("expected coverage of the newer snapshot but got %+v of %s (%d loads)", got, gotTs, loads)
+	}
+}
@@ -3,14 +3,14 @@ package eurostat
 import (
 	"bytes"
 	"compress/gzip"
-	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -23,12 +23,20 @@ const (
 	dataFileExtension = ".tsv.gz"
 )
 
+const (
+	// FlagMissing marks a week without reported value (":" in Eurostat data).
+	FlagMissing uint8 = 1 << iota
+	// FlagProvisional marks a provisional value ("p" in Eurostat data).
+	FlagProvisional
+)
+
 // WeeklyDeaths represents a number of deaths reported
 // for given week. Lack of information is represented
-// with -1 value.
+// with 0 value and FlagMissing flag.
 type WeeklyDeaths struct {
 	Week   uint8  `json:"week"`
 	Deaths uint32 `json:"deaths"`
+	Flags  uint8  `json:"flags,omitempty"`
 }
 
 type WeekYearDeaths struct {
@@ -69,6 +77,9 @@ type WeeklyDeathsRecord struct {
 type DataSnapshot struct {
 	Data      map[string][]WeeklyDeaths
 	Timestamp time.Time
+	// SourceHash is hash of the raw data the snapshot was parsed
+	// from (see SourceHash), zero if unknown.
+	SourceHash [sha256.Size]byte
 }
 
 // makeKey creates a string key used for storing the data in
@@ -81,6 +92,28 @@ func makeKey(country string, gender string, age string, year int) (string, error
 	return fmt.Sprintf("%s|%d|%s|%s", country, year, age, gender), nil
 }
 
+// parseKey splits a key created by makeKey back into
+// record metadata and year.
+func parseKey(key string) (Metadata, int, error) {
+	var metadata Metadata
+
+	parts := strings.Split(key, "|")
+	if len(parts) != 4 {
+		return metadata, 0, fmt.Errorf("bad key %s", key)
+	}
+
+	year, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return metadata, 0, fmt.Errorf("extracting year from key %s: %w", key, err)
+	}
+
+	return Metadata{
+		Country: parts[0],
+		Age:     parts[2],
+		Gender:  parts[3],
+	}, year, nil
+}
+
 func makeRange(from int, to int) []int {
 	rng := make([]int, 0)
 	if from > to {
@@ -121,89 +154,192 @@ func timestampFromFileName(filePath string) (time.Time, error) {
 	return ts, nil
 }
 
+// ErrNoSnapshotFiles is returned when a directory has no snapshot
+// files named according to timestampLayout.
+var ErrNoSnapshotFiles = errors.New("no YYYYMMDDTHHMMSS.tsv.gz snapshot files found")
+
+// LatestSnapshotPath returns path of the newest snapshot file in dir
+// (by the timestamp in its name) along with the timestamp.
+func LatestSnapshotPath(dir string) (string, time.Time, error) {
+	var (
+		latest   string
+		latestTs time.Time
+	)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return latest, latestTs, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), dataFileExtension) {
+			continue
+		}
+		ts, err := parseTimestamp(e.Name())
+		if err != nil {
+			continue
+		}
+		if latest == "" || ts.After(latestTs) {
+			latest, latestTs = filepath.Join(dir, e.Name()), ts
+		}
+	}
+
+	if latest == "" {
+		return latest, latestTs, fmt.Errorf("%w in %s", ErrNoSnapshotFiles, dir)
+	}
+	return latest, latestTs, nil
+}
+
+// DataSnapshotFromPath reads snapshot from a local .tsv.gz file.
+// Snapshot timestamp is taken from the file name (see timestampLayout)
+// or, if the name doesn't follow the convention, from its modification time.
+//
+// Parsed snapshot is cached in binary format next to the file (see
+// EncodeSnapshot) and read from there on subsequent calls, as long as
+// the raw file doesn't change.
 func DataSnapshotFromPath(path string) (DataSnapshot, error) {
 	var ds DataSnapshot
-	file, err := os.Open(path)
+	raw, err := os.ReadFile(path)
 	if err != nil {
 		return ds, err
 	}
 
-	r, err := gzip.NewReader(file)
+	sourceHash := SourceHash(raw)
+	cachePath := binarySnapshotPath(path)
+	ds, err = readBinarySnapshot(cachePath, sourceHash)
+	if err == nil {
+		log.Printf("Snapshot read from binary cache %s.\n", cachePath)
+		return ds, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Binary cache %s not used: %s\n", cachePath, err)
+	}
+
+	ds, err = parseSnapshotFile(path, raw)
 	if err != nil {
 		return ds, err
 	}
 
-	parsedData, err := ParseData(r)
+	err = writeBinarySnapshot(cachePath, ds, sourceHash)
+	if err != nil {
+		log.Printf("Writing binary cache %s failed: %s\n", cachePath, err)
+	}
+	return ds, nil
+}
+
+// ParseSnapshotFile reads snapshot from a local .tsv.gz file like
+// DataSnapshotFromPath, but always parses it and never writes
+// the binary cache (e.g. for files that are only inspected).
+func ParseSnapshotFile(path string) (DataSnapshot, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return DataSnapshot{}, err
+	}
+
+	return parseSnapshotFile(path, raw)
+}
+
+func parseSnapshotFile(path string, raw []byte) (DataSnapshot, error) {
+	var ds DataSnapshot
+	parsedData, err := parseRawSnapshot(raw)
 	if err != nil {
 		return ds, err
 	}
 
 	ts, err := timestampFromFileName(path)
 	if err != nil {
-		return ds, err
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return ds, err
+		}
+		log.Printf("Unparsable timestamp in %s file name, using modification time instead.\n", path)
+		ts = info.ModTime().UTC().Truncate(time.Second)
 	}
 
 	ds.Data = parsedData
 	ds.Timestamp = ts
+	ds.SourceHash = SourceHash(raw)
 	return ds, nil
 }
 
-func persistSnapshot(b []byte, timestamp time.Time) {
+// parseRawSnapshot parses gzipped TSV data as published by Eurostat.
+func parseRawSnapshot(raw []byte) (map[string][]WeeklyDeaths, error) {
+	r, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseData(r)
+}
+
+// persistSnapshot stores snapshot downloaded from Eurostat (unless
+// it's the same as the latest stored one) and applies retention policy
+// if retention is set, header holds response headers.
+func persistSnapshot(b []byte, snapshot DataSnapshot, sourceURL string, header http.Header, retention bool) {
 	if os.Getenv("PERSIST_LIVE_SNAPSHOTS") != "true" {
 		return
 	}
 
-	smg, err := NewSnapshotManager(os.Getenv("S3_BUCKET"))
+	smg, err := NewSnapshotManagerFromEnv()
 	if err != nil {
 		log.Printf("Failed to create snapshot manager: %s", err)
 		return
 	}
 
-	err = smg.PersistSnapshot(bytes.NewReader(b), timestamp)
+	stored, err := smg.StoreSnapshot(b, snapshot, NewSnapshotManifest(b, snapshot, sourceURL, header))
 	if err != nil {
-		log.Printf("Failed to persist snapshot to S3: %s", err)
+		log.Printf("Failed to persist snapshot: %s", err)
 		return
 	}
 
-	log.Println("Snapshot successfully persisted to S3!")
-}
+	if !stored {
+		log.Println("Snapshot unchanged since the latest stored one; recorded check time only.")
+		return
+	}
+	log.Println("Snapshot successfully persisted!")
 
-func DataSnapshotFromEurostat() (DataSnapshot, error) {
-	var ds DataSnapshot
+	if retention {
+		applyRetentionFromEnv(&smg)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// quarantineSnapshot stores snapshot downloaded from Eurostat that failed
+// validation, so that it can be inspected later. Unlike regular snapshots,
+// it's quarantined whenever the snapshot store is configured.
+func quarantineSnapshot(b []byte, snapshot DataSnapshot, sourceURL string, header http.Header, verr *ValidationError) {
+	if os.Getenv("SNAPSHOTS_DIR") == "" && os.Getenv("S3_BUCKET") == "" {
+		return
+	}
 
-	req, err := http.NewRequest("GET", eurostatDataUrl, nil)
+	smg, err := NewSnapshotManagerFromEnv()
 	if err != nil {
-		return ds, err
+		log.Printf("Failed to create snapshot manager: %s", err)
+		return
 	}
 
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	err = smg.QuarantineSnapshot(b, NewSnapshotManifest(b, snapshot, sourceURL, header), verr.Failures)
 	if err != nil {
-		return ds, err
+		log.Printf("Failed to quarantine snapshot: %s", err)
+		return
 	}
-	defer resp.Body.Close()
-
-	timestamp := time.Now().UTC()
-	ds.Timestamp = timestamp
+	log.Println("Snapshot failing validation quarantined.")
+}
 
-	sb, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return ds, err
+// recordCheck notes in the latest persisted manifest that Eurostat
+// was checked for new data.
+func recordCheck(checkedAt time.Time) {
+	if os.Getenv("PERSIST_LIVE_SNAPSHOTS") != "true" {
+		return
 	}
 
-	r, err := gzip.NewReader(bytes.NewReader(sb))
+	smg, err := NewSnapshotManagerFromEnv()
 	if err != nil {
-		return ds, err
+		log.Printf("Failed to create snapshot manager: %s", err)
+		return
 	}
 
-	data, err := ParseData(r)
+	err = smg.RecordCheck(checkedAt)
 	if err != nil {
-		return ds, err
+		log.Printf("Failed to record check time: %s", err)
 	}
-	ds.Data = data
-
-	persistSnapshot(sb, timestamp)
-	return ds, nil
 }
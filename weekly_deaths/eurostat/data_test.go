@@ -1,6 +1,9 @@
 package eurostat
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -28,3 +31,34 @@ func TestTimestampFromFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestLatestSnapshotPath(t *testing.T) {
+	dir := t.TempDir()
+
+	_, _, err := LatestSnapshotPath(dir)
+	if !errors.Is(err, ErrNoSnapshotFiles) {
+		t.Fatalf("expected ErrNoSnapshotFiles but got %v", err)
+	}
+
+	for _, name := range []string{"20210112T102331.tsv.gz", "20210119T102331.tsv.gz", "20210126T102331.snap", "latest.tsv.gz", "20210105T102331.tsv.gz"} {
+		err = os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Mkdir(filepath.Join(dir, "20210201T102331.tsv.gz"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, ts, err := LatestSnapshotPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "20210119T102331.tsv.gz"); path != want {
+		t.Fatalf("expected %s but got %s", want, path)
+	}
+	if want := time.Date(2021, 1, 19, 10, 23, 31, 0, time.UTC); !ts.Equal(want) {
+		t.Fatalf("expected timestamp %s but got %s", want, ts)
+	}
+}
@@ -0,0 +1,237 @@
+package eurostat
+
+import (
+	"sort"
+	"time"
+)
+
+// ValueChange describes a weekly deaths value that differs
+// between two snapshots.
+type ValueChange struct {
+	Country     string  `json:"country"`
+	Age         string  `json:"age"`
+	Gender      string  `json:"gender"`
+	Year        int     `json:"year"`
+	Week        int     `json:"week"`
+	OldDeaths   uint32  `json:"old_deaths"`
+	NewDeaths   uint32  `json:"new_deaths"`
+	Delta       int64   `json:"delta"`
+	RelativePct float64 `json:"relative_pct"`
+}
+
+// FlagChange describes a weekly value whose flags (missing,
+// provisional) differ between two snapshots.
+type FlagChange struct {
+	Country        string `json:"country"`
+	Age            string `json:"age"`
+	Gender         string `json:"gender"`
+	Year           int    `json:"year"`
+	Week           int    `json:"week"`
+	OldProvisional bool   `json:"old_provisional"`
+	NewProvisional bool   `json:"new_provisional"`
+	OldMissing     bool   `json:"old_missing"`
+	NewMissing     bool   `json:"new_missing"`
+}
+
+// SeriesRef identifies a single yearly series of weekly deaths.
+type SeriesRef struct {
+	Country string `json:"country"`
+	Age     string `json:"age"`
+	Gender  string `json:"gender"`
+	Year    int    `json:"year"`
+}
+
+// SnapshotDiff is a summary of differences between two snapshots.
+type SnapshotDiff struct {
+	OldTimestamp  time.Time           `json:"old_timestamp"`
+	NewTimestamp  time.Time           `json:"new_timestamp"`
+	AddedSeries   []SeriesRef         `json:"added_series"`
+	RemovedSeries []SeriesRef         `json:"removed_series"`
+	ChangedValues []ValueChange       `json:"changed_values"`
+	FlagChanges   []FlagChange        `json:"flag_changes"`
+	NewWeeks      map[string][]string `json:"newly_reported_weeks"`
+}
+
+// DiffSummary contains numbers of differences between two snapshots.
+type DiffSummary struct {
+	OldTimestamp  time.Time      `json:"old_timestamp"`
+	NewTimestamp  time.Time      `json:"new_timestamp"`
+	AddedSeries   int            `json:"added_series"`
+	RemovedSeries int            `json:"removed_series"`
+	ChangedValues int            `json:"changed_values"`
+	FlagChanges   int            `json:"flag_changes"`
+	NewWeeks      map[string]int `json:"newly_reported_weeks"`
+}
+
+// Summary returns numbers of differences included in the diff.
+func (d SnapshotDiff) Summary() DiffSummary {
+	newWeeks := make(map[string]int, len(d.NewWeeks))
+	for country, weeks := range d.NewWeeks {
+		newWeeks[country] = len(weeks)
+	}
+
+	return DiffSummary{
+		OldTimestamp:  d.OldTimestamp,
+		NewTimestamp:  d.NewTimestamp,
+		AddedSeries:   len(d.AddedSeries),
+		RemovedSeries: len(d.RemovedSeries),
+		ChangedValues: len(d.ChangedValues),
+		FlagChanges:   len(d.FlagChanges),
+		NewWeeks:      newWeeks,
+	}
+}
+
+// DiffSnapshots compares two snapshots. Newly reported weeks (weeks
+// missing in the old snapshot and reported in the new one) are grouped
+// by country; such weeks are not listed as changed values.
+func DiffSnapshots(old DataSnapshot, new DataSnapshot) (SnapshotDiff, error) {
+	diff := SnapshotDiff{
+		OldTimestamp:  old.Timestamp,
+		NewTimestamp:  new.Timestamp,
+		AddedSeries:   make([]SeriesRef, 0),
+		RemovedSeries: make([]SeriesRef, 0),
+		ChangedValues: make([]ValueChange, 0),
+		FlagChanges:   make([]FlagChange, 0),
+		NewWeeks:      make(map[string][]string),
+	}
+	newWeeks := make(map[string]map[string]struct{})
+
+	for key := range old.Data {
+		if _, ok := new.Data[key]; ok {
+			continue
+		}
+
+		ref, err := seriesRefFromKey(key)
+		if err != nil {
+			return diff, err
+		}
+		diff.RemovedSeries = append(diff.RemovedSeries, ref)
+	}
+
+	for key, newValues := range new.Data {
+		ref, err := seriesRefFromKey(key)
+		if err != nil {
+			return diff, err
+		}
+
+		oldValues, ok := old.Data[key]
+		if !ok {
+			diff.AddedSeries = append(diff.AddedSeries, ref)
+		}
+
+		oldByWeek := make(map[uint8]WeeklyDeaths, len(oldValues))
+		for _, v := range oldValues {
+			oldByWeek[v.Week] = v
+		}
+
+		for _, nv := range newValues {
+			ov, existed := oldByWeek[nv.Week]
+			oldMissing := !existed || ov.Flags&FlagMissing != 0
+			newMissing := nv.Flags&FlagMissing != 0
+
+			if oldMissing && !newMissing {
+				woy := WeekOfYear{Year: ref.Year, Week: int(nv.Week)}
+				if _, ok := newWeeks[ref.Country]; !ok {
+					newWeeks[ref.Country] = make(map[string]struct{})
+				}
+				newWeeks[ref.Country][woy.String()] = struct{}{}
+				continue
+			}
+
+			if existed && ov.Flags != nv.Flags {
+				diff.FlagChanges = append(diff.FlagChanges, FlagChange{
+					Country:        ref.Country,
+					Age:            ref.Age,
+					Gender:         ref.Gender,
+					Year:           ref.Year,
+					Week:           int(nv.Week),
+					OldProvisional: ov.Flags&FlagProvisional != 0,
+					NewProvisional: nv.Flags&FlagProvisional != 0,
+					OldMissing:     oldMissing,
+					NewMissing:     newMissing,
+				})
+			}
+
+			if existed && ov.Deaths != nv.Deaths {
+				delta := int64(nv.Deaths) - int64(ov.Deaths)
+				var relative float64
+				if ov.Deaths != 0 {
+					relative = float64(delta) / float64(ov.Deaths) * 100
+				}
+
+				diff.ChangedValues = append(diff.ChangedValues, ValueChange{
+					Country:     ref.Country,
+					Age:         ref.Age,
+					Gender:      ref.Gender,
+					Year:        ref.Year,
+					Week:        int(nv.Week),
+					OldDeaths:   ov.Deaths,
+					NewDeaths:   nv.Deaths,
+					Delta:       delta,
+					RelativePct: relative,
+				})
+			}
+		}
+	}
+
+	for country, weeks := range newWeeks {
+		for w := range weeks {
+			diff.NewWeeks[country] = append(diff.NewWeeks[country], w)
+		}
+	}
+
+	sortDiff(&diff)
+	return diff, nil
+}
+
+func seriesRefFromKey(key string) (SeriesRef, error) {
+	metadata, year, err := parseKey(key)
+	if err != nil {
+		return SeriesRef{}, err
+	}
+
+	return SeriesRef{Country: metadata.Country, Age: metadata.Age, Gender: metadata.Gender, Year: year}, nil
+}
+
+func seriesRefLess(a SeriesRef, b SeriesRef) bool {
+	if a.Country != b.Country {
+		return a.Country < b.Country
+	}
+	if a.Year != b.Year {
+		return a.Year < b.Year
+	}
+	if a.Age != b.Age {
+		return a.Age < b.Age
+	}
+	return a.Gender < b.Gender
+}
+
+func sortDiff(diff *SnapshotDiff) {
+	sort.Slice(diff.AddedSeries, func(i, j int) bool {
+		return seriesRefLess(diff.AddedSeries[i], diff.AddedSeries[j])
+	})
+	sort.Slice(diff.RemovedSeries, func(i, j int) bool {
+		return seriesRefLess(diff.RemovedSeries[i], diff.RemovedSeries[j])
+	})
+	sort.Slice(diff.ChangedValues, func(i, j int) bool {
+		a, b := diff.ChangedValues[i], diff.ChangedValues[j]
+		ra := SeriesRef{Country: a.Country, Age: a.Age, Gender: a.Gender, Year: a.Year}
+		rb := SeriesRef{Country: b.Country, Age: b.Age, Gender: b.Gender, Year: b.Year}
+		if ra != rb {
+			return seriesRefLess(ra, rb)
+		}
+		return a.Week < b.Week
+	})
+	sort.Slice(diff.FlagChanges, func(i, j int) bool {
+		a, b := diff.FlagChanges[i], diff.FlagChanges[j]
+		ra := SeriesRef{Country: a.Country, Age: a.Age, Gender: a.Gender, Year: a.Year}
+		rb := SeriesRef{Country: b.Country, Age: b.Age, Gender: b.Gender, Year: b.Year}
+		if ra != rb {
+			return seriesRefLess(ra, rb)
+		}
+		return a.Week < b.Week
+	})
+	for country := range diff.NewWeeks {
+		sort.Strings(diff.NewWeeks[country])
+	}
+}
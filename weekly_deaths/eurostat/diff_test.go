@@ -0,0 +1,80 @@
+package eurostat
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	old := DataSnapshot{
+		Data: map[string][]WeeklyDeaths{
+			"PL|2021|TOTAL|T": {
+				{Week: 1, Deaths: 100, Flags: FlagProvisional},
+				{Week: 2, Deaths: 0, Flags: FlagMissing},
+			},
+			"DE|2021|TOTAL|T": {
+				{Week: 1, Deaths: 10},
+			},
+		},
+		Timestamp: ts,
+	}
+	new := DataSnapshot{
+		Data: map[string][]WeeklyDeaths{
+			"PL|2021|TOTAL|T": {
+				{Week: 1, Deaths: 110},
+				{Week: 2, Deaths: 90, Flags: FlagProvisional},
+			},
+			"FR|2021|TOTAL|T": {
+				{Week: 1, Deaths: 0, Flags: FlagMissing},
+			},
+		},
+		Timestamp: ts.AddDate(0, 0, 7),
+	}
+
+	got, err := DiffSnapshots(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SnapshotDiff{
+		OldTimestamp:  old.Timestamp,
+		NewTimestamp:  new.Timestamp,
+		AddedSeries:   []SeriesRef{{Country: "FR", Age: "TOTAL", Gender: "T", Year: 2021}},
+		RemovedSeries: []SeriesRef{{Country: "DE", Age: "TOTAL", Gender: "T", Year: 2021}},
+		ChangedValues: []ValueChange{
+			{
+				Country:     "PL",
+				Age:         "TOTAL",
+				Gender:      "T",
+				Year:        2021,
+				Week:        1,
+				OldDeaths:   100,
+				NewDeaths:   110,
+				Delta:       10,
+				RelativePct: 10,
+			},
+		},
+		FlagChanges: []FlagChange{
+			{
+				Country:        "PL",
+				Age:            "TOTAL",
+				Gender:         "T",
+				Year:           2021,
+				Week:           1,
+				OldProvisional: true,
+			},
+		},
+		NewWeeks: map[string][]string{"PL": {"2021W02"}},
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+
+	summary := got.Summary()
+	if summary.ChangedValues != 1 || summary.NewWeeks["PL"] != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
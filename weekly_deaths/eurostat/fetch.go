@@ -0,0 +1,489 @@
+package eurostat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFetchTimeout limits the time of a single download attempt.
+	DefaultFetchTimeout = 2 * time.Minute
+	// DefaultFetchAttempts is the number of download attempts made
+	// before giving up on transient errors.
+	DefaultFetchAttempts = 4
+	// DefaultFetchBackoff is the delay before the first retry,
+	// doubled with every subsequent one.
+	DefaultFetchBackoff = 2 * time.Second
+	// DefaultFetchMaxBackoff caps the delay between retries.
+	DefaultFetchMaxBackoff = 30 * time.Second
+	// DefaultMaxSnapshotSize limits the size of downloaded file
+	// (it's around 10 MiB as of 2023).
+	DefaultMaxSnapshotSize = 128 << 20
+)
+
+var (
+	// ErrNotModified is returned when Eurostat hasn't published
+	// new data since the fetch described by FetchConditions.
+	ErrNotModified = errors.New("data not modified since the last fetch")
+
+	ErrSnapshotTooLarge       = errors.New("downloaded snapshot exceeds maximum size")
+	ErrUnexpectedContentType  = errors.New("unexpected content type of downloaded snapshot")
+	ErrUnexpectedUpstreamCode = errors.New("unexpected upstream response status")
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// FetchConditions holds validators of previously fetched data, sent
+// as If-None-Match and If-Modified-Since headers of the next fetch.
+type FetchConditions struct {
+	ETag         string
+	LastModified string
+}
+
+func fetchConditionsFromHeader(header http.Header) FetchConditions {
+	return FetchConditions{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+}
+
+// IsZero tells whether there are no validators, so the fetch is unconditional.
+func (c FetchConditions) IsZero() bool {
+	return c.ETag == "" && c.LastModified == ""
+}
+
+// FetchStatus describes the latest check of Eurostat for new data.
+type FetchStatus struct {
+	CheckedAt    time.Time `json:"checked_at"`
+	NotModified  bool      `json:"not_modified"`
+	Attempts     int       `json:"attempts"`
+	Error        string    `json:"error,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+}
+
+// FetcherOptions configures downloads made by Fetcher.
+type FetcherOptions struct {
+	URL string
+	// Client is used for requests; a client with connection
+	// timeouts is created when it's nil.
+	Client *http.Client
+	// Timeout limits the time of a single attempt, including reading the body.
+	Timeout time.Duration
+	// Attempts is the maximum number of attempts (1 disables retries).
+	Attempts int
+	// Backoff is the delay before the first retry; it's doubled with every
+	// retry (up to MaxBackoff) and randomized by up to a half (jitter).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxSize limits the size of downloaded file in bytes.
+	MaxSize int64
+}
+
+// DefaultFetcherOptions returns options of downloads from Eurostat.
+func DefaultFetcherOptions() FetcherOptions {
+	return FetcherOptions{
+		URL:        eurostatDataUrl,
+		Timeout:    DefaultFetchTimeout,
+		Attempts:   DefaultFetchAttempts,
+		Backoff:    DefaultFetchBackoff,
+		MaxBackoff: DefaultFetchMaxBackoff,
+		MaxSize:    DefaultMaxSnapshotSize,
+	}
+}
+
+// FetcherOptionsFromEnv returns default options overridden with FETCH_TIMEOUT
+// (Go duration, e.g. 90s), FETCH_ATTEMPTS and FETCH_MAX_SIZE_BYTES env variables.
+func FetcherOptionsFromEnv() (FetcherOptions, error) {
+	opts := DefaultFetcherOptions()
+
+	if v := os.Getenv("FETCH_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("parsing FETCH_TIMEOUT: %w", err)
+		}
+		opts.Timeout = d
+	}
+
+	if v := os.Getenv("FETCH_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("parsing FETCH_ATTEMPTS: %w", err)
+		}
+		opts.Attempts = n
+	}
+
+	if v := os.Getenv("FETCH_MAX_SIZE_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("parsing FETCH_MAX_SIZE_BYTES: %w", err)
+		}
+		opts.MaxSize = n
+	}
+
+	return opts, nil
+}
+
+// newFetchClient creates HTTP client with connection level timeouts;
+// the overall time of an attempt is limited with FetcherOptions.Timeout.
+func newFetchClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// Fetcher downloads data from Eurostat, retrying on transient errors and
+// remembering validators of the last fetched data, so that subsequent
+// fetches are conditional. Fetched snapshots can be checked with
+// SnapshotValidator before they're persisted.
+type Fetcher struct {
+	opts  FetcherOptions
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu         sync.Mutex
+	conditions FetchConditions
+	status     *FetchStatus
+	validator  SnapshotValidator
+	lease      *Lease
+}
+
+func NewFetcher() *Fetcher {
+	return NewFetcherWithOptions(DefaultFetcherOptions())
+}
+
+// NewFetcherWithOptions creates Fetcher; zero options are replaced with defaults.
+func NewFetcherWithOptions(opts FetcherOptions) *Fetcher {
+	defaults := DefaultFetcherOptions()
+	if opts.URL == "" {
+		opts.URL = defaults.URL
+	}
+	if opts.Client == nil {
+		opts.Client = newFetchClient()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = defaults.Attempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaults.Backoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaults.MaxBackoff
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaults.MaxSize
+	}
+
+	return &Fetcher{opts: opts, sleep: sleepContext}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DataSnapshotFromEurostat downloads and parses the latest data
+// with default options.
+func DataSnapshotFromEurostat() (DataSnapshot, error) {
+	return NewFetcher().FetchLatest()
+}
+
+// SetValidator sets validator of fetched snapshots. Snapshots failing
+// validation are quarantined in the snapshot store (instead of persisted)
+// and returned with ValidationError.
+func (f *Fetcher) SetValidator(v SnapshotValidator) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.validator = v
+}
+
+func (f *Fetcher) validate(ds DataSnapshot) error {
+	f.mu.Lock()
+	validator := f.validator
+	f.mu.Unlock()
+
+	if validator == nil {
+		return nil
+	}
+	return validator(ds)
+}
+
+// SetLease makes retention policy applied after persisting a new snapshot
+// only while the lease is held, so that instances sharing the snapshot
+// store don't delete snapshots concurrently.
+func (f *Fetcher) SetLease(l *Lease) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lease = l
+}
+
+func (f *Fetcher) leader() bool {
+	f.mu.Lock()
+	lease := f.lease
+	f.mu.Unlock()
+
+	return lease == nil || lease.IsLeader()
+}
+
+// LoadConditions makes the next fetch conditional on validators
+// of the latest stored snapshot. It should be used when the data
+// was loaded from the snapshot store instead of Eurostat.
+func (f *Fetcher) LoadConditions(sm *SnapshotManager) error {
+	manifest, err := sm.LatestManifest()
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.conditions = FetchConditions{ETag: manifest.ETag, LastModified: manifest.LastModified}
+	return nil
+}
+
+// FetchLatest downloads the data unconditionally.
+func (f *Fetcher) FetchLatest() (DataSnapshot, error) {
+	return f.fetch(FetchConditions{})
+}
+
+// FetchIfModified downloads the data unless it hasn't changed since
+// the last fetch, in which case ErrNotModified is returned.
+func (f *Fetcher) FetchIfModified() (DataSnapshot, error) {
+	f.mu.Lock()
+	cond := f.conditions
+	f.mu.Unlock()
+
+	return f.fetch(cond)
+}
+
+func (f *Fetcher) fetch(cond FetchConditions) (DataSnapshot, error) {
+	ds, newCond, attempts, err := f.fetchWithRetries(context.Background(), cond)
+
+	status := FetchStatus{
+		CheckedAt:    time.Now().UTC(),
+		NotModified:  errors.Is(err, ErrNotModified),
+		Attempts:     attempts,
+		ETag:         newCond.ETag,
+		LastModified: newCond.LastModified,
+	}
+	if err != nil && !status.NotModified {
+		status.Error = err.Error()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// the same invalid data isn't downloaded again
+	// until Eurostat publishes something new
+	if err == nil || errors.Is(err, ErrSnapshotValidationFailed) {
+		f.conditions = newCond
+	}
+	f.status = &status
+	return ds, err
+}
+
+// URL returns the address snapshots are fetched from.
+func (f *Fetcher) URL() string {
+	return f.opts.URL
+}
+
+// Status returns outcome of the latest fetch (nil if there was none).
+func (f *Fetcher) Status() *FetchStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status == nil {
+		return nil
+	}
+	status := *f.status
+	return &status
+}
+
+// transientError marks errors worth retrying; retryAfter
+// is the delay requested by the server (if any).
+type transientError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// backoff returns randomized delay before given retry (starting with 1).
+func (f *Fetcher) backoff(retry int) time.Duration {
+	d := f.opts.Backoff
+	for i := 1; i < retry && d < f.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > f.opts.MaxBackoff {
+		d = f.opts.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (f *Fetcher) fetchWithRetries(ctx context.Context, cond FetchConditions) (DataSnapshot, FetchConditions, int, error) {
+	for attempt := 1; ; attempt++ {
+		ds, newCond, err := f.fetchOnce(ctx, cond)
+
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) || attempt >= f.opts.Attempts {
+			if attempt > 1 && err != nil {
+				err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return ds, newCond, attempt, err
+		}
+
+		delay := f.backoff(attempt)
+		if transient.retryAfter > delay {
+			delay = transient.retryAfter
+			if delay > f.opts.MaxBackoff {
+				delay = f.opts.MaxBackoff
+			}
+		}
+		log.Printf("Fetching data from Eurostat failed (attempt %d of %d), retrying in %s: %s\n", attempt, f.opts.Attempts, delay, err)
+
+		err = f.sleep(ctx, delay)
+		if err != nil {
+			return ds, cond, attempt, err
+		}
+	}
+}
+
+// retryAfter parses Retry-After header given in seconds.
+func retryAfter(header http.Header) time.Duration {
+	s, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || s < 0 {
+		return 0
+	}
+	return time.Duration(s) * time.Second
+}
+
+// checkContentType rejects responses that are clearly not the data file
+// (like HTML error pages served with 200 status).
+func checkContentType(header http.Header) error {
+	v := header.Get("Content-Type")
+	if v == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnexpectedContentType, v)
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml") {
+		return fmt.Errorf("%w: %s", ErrUnexpectedContentType, mediaType)
+	}
+	return nil
+}
+
+func (f *Fetcher) fetchOnce(ctx context.Context, cond FetchConditions) (DataSnapshot, FetchConditions, error) {
+	var ds DataSnapshot
+
+	ctx, cancel := context.WithTimeout(ctx, f.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.opts.URL, nil)
+	if err != nil {
+		return ds, cond, err
+	}
+	if cond.ETag != "" {
+		req.Header.Set("If-None-Match", cond.ETag)
+	}
+	if cond.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cond.LastModified)
+	}
+
+	resp, err := f.opts.Client.Do(req)
+	if err != nil {
+		// network errors and timeouts of the attempt
+		return ds, cond, &transientError{err: err}
+	}
+	defer resp.Body.Close()
+
+	// snapshots are stored under keys with second precision (timestampLayout)
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	ds.Timestamp = timestamp
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		recordCheck(timestamp)
+		return ds, cond, ErrNotModified
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return ds, cond, &transientError{
+			err:        fmt.Errorf("%w: %s", ErrUnexpectedUpstreamCode, resp.Status),
+			retryAfter: retryAfter(resp.Header),
+		}
+	case resp.StatusCode != http.StatusOK:
+		return ds, cond, fmt.Errorf("%w: %s", ErrUnexpectedUpstreamCode, resp.Status)
+	}
+
+	err = checkContentType(resp.Header)
+	if err != nil {
+		return ds, cond, err
+	}
+
+	if resp.ContentLength > f.opts.MaxSize {
+		return ds, cond, fmt.Errorf("%w: %d bytes", ErrSnapshotTooLarge, resp.ContentLength)
+	}
+
+	sb, err := io.ReadAll(io.LimitReader(resp.Body, f.opts.MaxSize+1))
+	if err != nil {
+		return ds, cond, &transientError{err: fmt.Errorf("reading response body: %w", err)}
+	}
+	if int64(len(sb)) > f.opts.MaxSize {
+		return ds, cond, fmt.Errorf("%w: more than %d bytes", ErrSnapshotTooLarge, f.opts.MaxSize)
+	}
+	if !bytes.HasPrefix(sb, gzipMagic) {
+		return ds, cond, fmt.Errorf("%w: body is not gzip compressed", ErrUnexpectedContentType)
+	}
+
+	data, err := parseRawSnapshot(sb)
+	if err != nil {
+		return ds, cond, err
+	}
+	ds.Data = data
+	ds.SourceHash = SourceHash(sb)
+
+	err = f.validate(ds)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		quarantineSnapshot(sb, ds, f.opts.URL, resp.Header, verr)
+		return ds, fetchConditionsFromHeader(resp.Header), err
+	}
+	if err != nil {
+		return ds, cond, err
+	}
+
+	persistSnapshot(sb, ds, f.opts.URL, resp.Header, f.leader())
+	return ds, fetchConditionsFromHeader(resp.Header), nil
+}
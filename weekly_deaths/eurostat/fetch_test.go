@@ -0,0 +1,266 @@
+package eurostat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetcherConditionalDownloads(t *testing.T) {
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+
+	var requests []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Clone())
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2023 03:04:05 GMT")
+		w.Write(raw)
+	}))
+	defer srv.Close()
+
+	f := NewFetcherWithOptions(FetcherOptions{URL: srv.URL})
+
+	if f.Status() != nil {
+		t.Fatal("expected no status before the first fetch")
+	}
+
+	ds, err := f.FetchIfModified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Data["PL|2021|TOTAL|T"][0].Deaths != 1 {
+		t.Fatalf("unexpected data %+v", ds.Data)
+	}
+	if !ds.Timestamp.Equal(ds.Timestamp.Truncate(time.Second)) {
+		t.Fatalf("expected timestamp with second precision of stored snapshots but got %s", ds.Timestamp)
+	}
+	if requests[0].Get("If-None-Match") != "" || requests[0].Get("If-Modified-Since") != "" {
+		t.Fatalf("expected the first fetch to be unconditional but got %v", requests[0])
+	}
+
+	_, err = f.FetchIfModified()
+	if !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected ErrNotModified but got %v", err)
+	}
+	if got := requests[1].Get("If-Modified-Since"); got != "Mon, 02 Jan 2023 03:04:05 GMT" {
+		t.Fatalf("expected If-Modified-Since to be sent but got %q", got)
+	}
+
+	status := f.Status()
+	if status == nil || !status.NotModified || status.Error != "" || status.ETag != `"v1"` {
+		t.Fatalf("expected not modified status but got %+v", status)
+	}
+
+	_, err = f.FetchLatest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests[2].Get("If-None-Match") != "" {
+		t.Fatal("expected FetchLatest to be unconditional")
+	}
+	if f.Status().NotModified {
+		t.Fatal("expected status of full download")
+	}
+}
+
+func TestFetcherLoadsConditionsFromManifest(t *testing.T) {
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+	data, err := parseRawSnapshot(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := DataSnapshot{Data: data, Timestamp: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	sm := NewSnapshotManagerWithStore(NewMemorySnapshotStore())
+	_, err = sm.StoreSnapshot(raw, ds, NewSnapshotManifest(raw, ds, "", http.Header{"Etag": []string{`"v1"`}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ifNoneMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+
+	f := NewFetcherWithOptions(FetcherOptions{URL: srv.URL})
+	err = f.LoadConditions(&sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.FetchIfModified()
+	if !errors.Is(err, ErrNotModified) || ifNoneMatch != `"v1"` {
+		t.Fatalf("expected conditional fetch with stored ETag but got %v (If-None-Match: %q)", err, ifNoneMatch)
+	}
+}
+
+// newTestingFetcher creates fetcher that records retry delays instead of sleeping.
+func newTestingFetcher(opts FetcherOptions, delays *[]time.Duration) *Fetcher {
+	f := NewFetcherWithOptions(opts)
+	f.sleep = func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	return f
+}
+
+func TestFetcherRetriesTransientErrors(t *testing.T) {
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+
+	responses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := responses[requests]
+		requests++
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "5")
+		}
+		if code != http.StatusOK {
+			http.Error(w, "try later", code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(raw)
+	}))
+	defer srv.Close()
+
+	var delays []time.Duration
+	f := newTestingFetcher(FetcherOptions{URL: srv.URL, Attempts: 3, Backoff: time.Second, MaxBackoff: time.Minute}, &delays)
+
+	_, err := f.FetchLatest()
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	if requests != 3 || len(delays) != 2 {
+		t.Fatalf("expected 3 requests and 2 retries but got %d and %v", requests, delays)
+	}
+	if delays[0] < 500*time.Millisecond || delays[0] > time.Second {
+		t.Fatalf("expected first delay between 0.5s and 1s but got %s", delays[0])
+	}
+	if delays[1] != 5*time.Second {
+		t.Fatalf("expected delay requested with Retry-After but got %s", delays[1])
+	}
+	if status := f.Status(); status.Attempts != 3 || status.Error != "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestFetcherGivesUp(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	var delays []time.Duration
+	f := newTestingFetcher(FetcherOptions{URL: srv.URL, Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second}, &delays)
+
+	_, err := f.FetchLatest()
+	if !errors.Is(err, ErrUnexpectedUpstreamCode) {
+		t.Fatalf("expected ErrUnexpectedUpstreamCode but got %v", err)
+	}
+	if requests != 4 {
+		t.Fatalf("expected 4 requests but got %d", requests)
+	}
+	for i, d := range delays {
+		if d > 3*time.Second {
+			t.Fatalf("retry %d: expected delay capped at 3s but got %s", i+1, d)
+		}
+	}
+	if status := f.Status(); status.Error == "" || status.Attempts != 4 {
+		t.Fatalf("expected failed status but got %+v", status)
+	}
+}
+
+func TestFetcherRejectsBadResponses(t *testing.T) {
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		maxSize int64
+		want    error
+	}{
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "not found", http.StatusNotFound)
+			},
+			want: ErrUnexpectedUpstreamCode,
+		},
+		{
+			name: "html page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte("<html>maintenance</html>"))
+			},
+			want: ErrUnexpectedContentType,
+		},
+		{
+			name: "not gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte("plain data"))
+			},
+			want: ErrUnexpectedContentType,
+		},
+		{
+			name: "too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(raw)
+			},
+			maxSize: int64(len(raw) - 1),
+			want:    ErrSnapshotTooLarge,
+		},
+	}
+
+	for _, c := range cases {
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			c.handler(w, r)
+		}))
+
+		var delays []time.Duration
+		f := newTestingFetcher(FetcherOptions{URL: srv.URL, MaxSize: c.maxSize}, &delays)
+		_, err := f.FetchLatest()
+		srv.Close()
+
+		if !errors.Is(err, c.want) {
+			t.Fatalf("%s: expected %v but got %v", c.name, c.want, err)
+		}
+		if requests != 1 {
+			t.Fatalf("%s: expected no retries but got %d requests", c.name, requests)
+		}
+	}
+}
+
+func TestFetcherTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var delays []time.Duration
+	f := newTestingFetcher(FetcherOptions{URL: srv.URL, Attempts: 2, Timeout: 50 * time.Millisecond}, &delays)
+
+	_, err := f.FetchLatest()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout but got %v", err)
+	}
+	if len(delays) != 1 {
+		t.Fatalf("expected timed out attempt to be retried but got %v", delays)
+	}
+}
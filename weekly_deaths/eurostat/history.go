@@ -0,0 +1,214 @@
+package eurostat
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistoryCacheSize is the default number of older snapshots
+	// kept in memory by InMemoryDB.
+	DefaultHistoryCacheSize = 3
+
+	// how long the list of stored snapshots is reused before
+	// it's fetched from the source again
+	historyListTTL = 5 * time.Minute
+)
+
+var (
+	ErrNoSnapshotAsOf = errors.New("no snapshot available for given point in time")
+)
+
+// SnapshotSource provides access to stored snapshots by their timestamps.
+type SnapshotSource interface {
+	SnapshotTimestamps() ([]time.Time, error)
+	SnapshotAt(ts time.Time) (DataSnapshot, error)
+}
+
+// snapshotCache is an LRU cache of indexed snapshots keyed by their timestamps.
+// It holds a reference to every cached snapshot, released on eviction.
+type snapshotCache struct {
+	capacity int
+	items    map[int64]*list.Element
+	order    *list.List
+}
+
+func newSnapshotCache(capacity int) *snapshotCache {
+	if capacity <= 0 {
+		capacity = DefaultHistoryCacheSize
+	}
+
+	return &snapshotCache{
+		capacity: capacity,
+		items:    make(map[int64]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached snapshot with a reference taken for the caller.
+func (c *snapshotCache) get(ts time.Time) (*dbSnapshot, bool) {
+	el, ok := c.items[ts.UnixNano()]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	snapshot := el.Value.(*dbSnapshot)
+	snapshot.acquire()
+	return snapshot, true
+}
+
+// add caches the snapshot, taking a reference to it.
+func (c *snapshotCache) add(snapshot *dbSnapshot) {
+	if !snapshot.acquire() {
+		return
+	}
+
+	key := snapshot.timestamp.UnixNano()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*dbSnapshot).release()
+		el.Value = snapshot
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(snapshot)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		evicted := oldest.Value.(*dbSnapshot)
+		delete(c.items, evicted.timestamp.UnixNano())
+		evicted.release()
+	}
+}
+
+// historyLoad is a snapshot being loaded from the source, shared
+// by all queries asking for it meanwhile.
+type historyLoad struct {
+	done     chan struct{}
+	snapshot *dbSnapshot
+	err      error
+}
+
+// snapshotHistory gives access to older snapshots, loading them lazily
+// from the source and keeping the recently used ones in memory.
+type snapshotHistory struct {
+	source SnapshotSource
+	build  func(DataSnapshot) (*dbSnapshot, error)
+
+	mu         sync.Mutex
+	cache      *snapshotCache
+	loading    map[int64]*historyLoad
+	timestamps []time.Time
+	listedAt   time.Time
+}
+
+func newSnapshotHistory(source SnapshotSource, cacheSize int, build func(DataSnapshot) (*dbSnapshot, error)) *snapshotHistory {
+	return &snapshotHistory{
+		source:  source,
+		build:   build,
+		cache:   newSnapshotCache(cacheSize),
+		loading: make(map[int64]*historyLoad),
+	}
+}
+
+// closestTimestamp returns the timestamp of the most recent
+// stored snapshot that is not later than asOf.
+func (h *snapshotHistory) closestTimestamp(asOf time.Time) (time.Time, error) {
+	if h.timestamps == nil || time.Since(h.listedAt) > historyListTTL {
+		timestamps, err := h.source.SnapshotTimestamps()
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		sort.Slice(timestamps, func(i, j int) bool {
+			return timestamps[i].Before(timestamps[j])
+		})
+		h.timestamps = timestamps
+		h.listedAt = time.Now()
+	}
+
+	i := sort.Search(len(h.timestamps), func(i int) bool {
+		return h.timestamps[i].After(asOf)
+	})
+	if i == 0 {
+		return time.Time{}, ErrNoSnapshotAsOf
+	}
+
+	return h.timestamps[i-1], nil
+}
+
+// snapshotAsOf returns the most recent stored snapshot that is not later
+// than asOf, acquired for the caller. The snapshot is downloaded and indexed
+// without holding the lock, so queries served from the cache don't wait
+// for it, and only once: concurrent queries for the same snapshot wait
+// for the load in progress.
+func (h *snapshotHistory) snapshotAsOf(asOf time.Time) (*dbSnapshot, error) {
+	for {
+		h.mu.Lock()
+		ts, err := h.closestTimestamp(asOf)
+		if err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		if snapshot, ok := h.cache.get(ts); ok {
+			h.mu.Unlock()
+			return snapshot, nil
+		}
+
+		l, loading := h.loading[ts.UnixNano()]
+		if !loading {
+			l = &historyLoad{done: make(chan struct{})}
+			h.loading[ts.UnixNano()] = l
+		}
+		h.mu.Unlock()
+
+		if !loading {
+			return h.load(ts, l)
+		}
+
+		<-l.done
+		if l.err != nil {
+			return nil, l.err
+		}
+		// unless the snapshot was already evicted and closed
+		if l.snapshot.acquire() {
+			return l.snapshot, nil
+		}
+	}
+}
+
+// load loads the snapshot with given timestamp from the source, caches it
+// and shares it with queries waiting for l.
+func (h *snapshotHistory) load(ts time.Time, l *historyLoad) (*dbSnapshot, error) {
+	ds, err := h.source.SnapshotAt(ts)
+	if err == nil {
+		l.snapshot, err = h.build(ds)
+	}
+	l.err = err
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.loading, ts.UnixNano())
+	if err == nil {
+		h.cache.add(l.snapshot)
+	}
+	close(l.done)
+	return l.snapshot, l.err
+}
+
+// remember keeps the snapshot replaced in InMemoryDB in the cache
+// and forces the list of stored snapshots to be fetched again.
+func (h *snapshotHistory) remember(snapshot *dbSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if snapshot != nil && !snapshot.timestamp.IsZero() {
+		h.cache.add(snapshot)
+	}
+	h.timestamps = nil
+}
@@ -0,0 +1,210 @@
+package eurostat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type testingSnapshotSource struct {
+	snapshots map[int64]DataSnapshot
+	loads     int
+}
+
+func (s *testingSnapshotSource) SnapshotTimestamps() ([]time.Time, error) {
+	timestamps := make([]time.Time, 0, len(s.snapshots))
+	for _, ds := range s.snapshots {
+		timestamps = append(timestamps, ds.Timestamp)
+	}
+	return timestamps, nil
+}
+
+func (s *testingSnapshotSource) SnapshotAt(ts time.Time) (DataSnapshot, error) {
+	s.loads++
+	ds, ok := s.snapshots[ts.UnixNano()]
+	if !ok {
+		return ds, errors.New("snapshot not found")
+	}
+	return ds, nil
+}
+
+func testingHistorySnapshot(ts time.Time, deaths uint32) DataSnapshot {
+	return DataSnapshot{
+		Data:      map[string][]WeeklyDeaths{"PL|2021|TOTAL|T": {{Week: 1, Deaths: deaths}}},
+		Timestamp: ts,
+	}
+}
+
+func TestSnapshotAsOf(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	source := &testingSnapshotSource{snapshots: make(map[int64]DataSnapshot)}
+	for i := 0; i < 3; i++ {
+		ds := testingHistorySnapshot(ts.AddDate(0, 0, 7*i), uint32(i))
+		source.snapshots[ds.Timestamp.UnixNano()] = ds
+	}
+
+	db := DBFromSnapshot(testingHistorySnapshot(ts.AddDate(0, 0, 21), 3))
+	db.EnableHistory(source, 1)
+
+	type TestCase struct {
+		asOf       time.Time
+		wantDeaths uint32
+	}
+
+	cases := []TestCase{
+		{asOf: ts.AddDate(1, 0, 0), wantDeaths: 3},
+		{asOf: ts.AddDate(0, 0, 21), wantDeaths: 3},
+		{asOf: ts.AddDate(0, 0, 20), wantDeaths: 2},
+		{asOf: ts.AddDate(0, 0, 8), wantDeaths: 1},
+		{asOf: ts, wantDeaths: 0},
+		{asOf: ts.AddDate(0, 0, 8), wantDeaths: 1},
+	}
+
+	for _, c := range cases {
+		ds, err := db.SnapshotAsOf(c.asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := ds.Data["PL|2021|TOTAL|T"][0].Deaths; got != c.wantDeaths {
+			t.Fatalf("as of %s: expected %d deaths but got %d", c.asOf, c.wantDeaths, got)
+		}
+	}
+
+	if source.loads != 4 {
+		t.Fatalf("expected 4 snapshot loads with cache of size 1 but got %d", source.loads)
+	}
+
+	_, err := db.SnapshotAsOf(ts.Add(-time.Second))
+	if !errors.Is(err, ErrNoSnapshotAsOf) {
+		t.Fatalf("expected ErrNoSnapshotAsOf but got %v", err)
+	}
+}
+
+func TestSnapshotCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	cache := newSnapshotCache(2)
+
+	for i := 0; i < 3; i++ {
+		snapshot, err := newDBSnapshot(testingHistorySnapshot(ts.Add(time.Duration(i)*time.Hour), uint32(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache.add(snapshot)
+		if i == 1 {
+			cache.get(ts)
+		}
+	}
+
+	if _, ok := cache.get(ts); !ok {
+		t.Fatal("expected recently used snapshot to be kept in cache")
+	}
+
+	if _, ok := cache.get(ts.Add(time.Hour)); ok {
+		t.Fatal("expected least recently used snapshot to be evicted")
+	}
+}
+
+// blockingSnapshotSource blocks loading the snapshot with given
+// timestamp until release is closed.
+type blockingSnapshotSource struct {
+	*testingSnapshotSource
+	blocked time.Time
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSnapshotSource) SnapshotAt(ts time.Time) (DataSnapshot, error) {
+	if ts.Equal(s.blocked) {
+		close(s.loading)
+		<-s.release
+	}
+	return s.testingSnapshotSource.SnapshotAt(ts)
+}
+
+func TestSnapshotAsOfDoesNotWaitForDownloads(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	source := &blockingSnapshotSource{
+		testingSnapshotSource: &testingSnapshotSource{snapshots: make(map[int64]DataSnapshot)},
+		blocked:               ts,
+		loading:               make(chan struct{}),
+		release:               make(chan struct{}),
+	}
+	for i := 0; i < 2; i++ {
+		ds := testingHistorySnapshot(ts.AddDate(0, 0, 7*i), uint32(i))
+		source.snapshots[ds.Timestamp.UnixNano()] = ds
+	}
+
+	db := DBFromSnapshot(testingHistorySnapshot(ts.AddDate(0, 0, 14), 2))
+	db.EnableHistory(source, 2)
+
+	_, err := db.SnapshotAsOf(ts.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := db.SnapshotAsOf(ts)
+		done <- err
+	}()
+	<-source.loading
+
+	// cached snapshot is served while the other one is being downloaded
+	ds, err := db.SnapshotAsOf(ts.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ds.Data["PL|2021|TOTAL|T"][0].Deaths; got != 1 {
+		t.Fatalf("expected 1 death but got %d", got)
+	}
+
+	close(source.release)
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotAsOfLoadsSnapshotOnce(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	source := &blockingSnapshotSource{
+		testingSnapshotSource: &testingSnapshotSource{snapshots: make(map[int64]DataSnapshot)},
+		blocked:               ts,
+		loading:               make(chan struct{}),
+		release:               make(chan struct{}),
+	}
+	ds := testingHistorySnapshot(ts, 1)
+	source.snapshots[ds.Timestamp.UnixNano()] = ds
+
+	db := DBFromSnapshot(testingHistorySnapshot(ts.AddDate(0, 0, 7), 2))
+	db.EnableHistory(source, 2)
+
+	const queries = 5
+	errs := make(chan error, queries)
+	for i := 0; i < queries; i++ {
+		go func() {
+			ds, err := db.SnapshotAsOf(ts)
+			if err == nil && ds.Data["PL|2021|TOTAL|T"][0].Deaths != 1 {
+				err = errors.New("unexpected snapshot")
+			}
+			errs <- err
+		}()
+	}
+
+	// let other queries find the load in progress
+	<-source.loading
+	time.Sleep(10 * time.Millisecond)
+	close(source.release)
+
+	for i := 0; i < queries; i++ {
+		err := <-errs
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if source.loads != 1 {
+		t.Fatalf("expected snapshot to be loaded once but got %d loads", source.loads)
+	}
+}
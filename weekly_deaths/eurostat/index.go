@@ -0,0 +1,205 @@
+package eurostat
+
+import (
+	"sort"
+)
+
+// dimension interns values of a single dimension (country, age, gender)
+// as ordinals of their sorted values.
+type dimension struct {
+	values   []string
+	ordinals map[string]int
+}
+
+func newDimension(values map[string]struct{}) dimension {
+	d := dimension{
+		values:   sortedKeys(values),
+		ordinals: make(map[string]int, len(values)),
+	}
+	for i, v := range d.values {
+		d.ordinals[v] = i
+	}
+	return d
+}
+
+// seriesIndex is a compact, columnar representation of snapshot data.
+// Dimension values are interned and every (country, age, gender, year)
+// combination is assigned a slot in a dense cube; values of a slot are
+// stored contiguously in weeks, deaths and flags columns, between
+// starts[slot] and starts[slot+1]. Slots of consecutive years of
+// a series are adjacent, so a range of years is a single range of values.
+type seriesIndex struct {
+	countries dimension
+	ages      dimension
+	genders   dimension
+	yearFrom  int
+	years     int
+
+	starts []uint32
+	weeks  []uint8
+	deaths []uint32
+	flags  []uint8
+
+	// mapping keeps the file the columns are read from mapped
+	// (nil if they're stored on the heap), see openMappedSnapshot
+	mapping *mappedFile
+}
+
+func (idx *seriesIndex) slot(country int, age int, gender int, yearOffset int) int {
+	return ((country*len(idx.ages.values)+age)*len(idx.genders.values)+gender)*idx.years + yearOffset
+}
+
+// newSeriesIndex builds an index from data keyed with makeKey.
+func newSeriesIndex(data map[string][]WeeklyDeaths) (*seriesIndex, error) {
+	type parsedKey struct {
+		metadata Metadata
+		year     int
+		values   []WeeklyDeaths
+	}
+
+	var (
+		countries = make(map[string]struct{})
+		ages      = make(map[string]struct{})
+		genders   = make(map[string]struct{})
+		parsed    = make([]parsedKey, 0, len(data))
+		yearTo    int
+		total     int
+	)
+
+	idx := &seriesIndex{}
+	for key, values := range data {
+		metadata, year, err := parseKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		countries[metadata.Country] = struct{}{}
+		ages[metadata.Age] = struct{}{}
+		genders[metadata.Gender] = struct{}{}
+		if idx.yearFrom == 0 || year < idx.yearFrom {
+			idx.yearFrom = year
+		}
+		if year > yearTo {
+			yearTo = year
+		}
+
+		parsed = append(parsed, parsedKey{metadata: metadata, year: year, values: values})
+		total += len(values)
+	}
+
+	idx.countries = newDimension(countries)
+	idx.ages = newDimension(ages)
+	idx.genders = newDimension(genders)
+	if len(parsed) > 0 {
+		idx.years = yearTo - idx.yearFrom + 1
+	}
+
+	slots := len(idx.countries.values) * len(idx.ages.values) * len(idx.genders.values) * idx.years
+	bySlot := make([][]WeeklyDeaths, slots)
+	for _, p := range parsed {
+		s := idx.slot(
+			idx.countries.ordinals[p.metadata.Country],
+			idx.ages.ordinals[p.metadata.Age],
+			idx.genders.ordinals[p.metadata.Gender],
+			p.year-idx.yearFrom,
+		)
+		bySlot[s] = p.values
+	}
+
+	idx.starts = make([]uint32, slots+1)
+	idx.weeks = make([]uint8, 0, total)
+	idx.deaths = make([]uint32, 0, total)
+	idx.flags = make([]uint8, 0, total)
+	for s, values := range bySlot {
+		idx.starts[s] = uint32(len(idx.weeks))
+		if !sort.SliceIsSorted(values, func(i, j int) bool { return values[i].Week < values[j].Week }) {
+			values = append([]WeeklyDeaths(nil), values...)
+			sort.Slice(values, func(i, j int) bool { return values[i].Week < values[j].Week })
+		}
+		for _, v := range values {
+			idx.weeks = append(idx.weeks, v.Week)
+			idx.deaths = append(idx.deaths, v.Deaths)
+			idx.flags = append(idx.flags, v.Flags)
+		}
+	}
+	idx.starts[slots] = uint32(len(idx.weeks))
+
+	return idx, nil
+}
+
+// weeklyDeaths returns values of a series for given range of years.
+func (idx *seriesIndex) weeklyDeaths(country string, age string, gender string, yearFrom int, yearTo int) []WeekYearDeaths {
+	res := make([]WeekYearDeaths, 0)
+
+	c, okC := idx.countries.ordinals[country]
+	a, okA := idx.ages.ordinals[age]
+	g, okG := idx.genders.ordinals[gender]
+	if !okC || !okA || !okG {
+		return res
+	}
+
+	if yearFrom < idx.yearFrom {
+		yearFrom = idx.yearFrom
+	}
+	if last := idx.yearFrom + idx.years - 1; yearTo > last {
+		yearTo = last
+	}
+	if yearFrom > yearTo {
+		return res
+	}
+
+	first := idx.slot(c, a, g, yearFrom-idx.yearFrom)
+	last := idx.slot(c, a, g, yearTo-idx.yearFrom)
+	res = make([]WeekYearDeaths, 0, idx.starts[last+1]-idx.starts[first])
+	for s := first; s <= last; s++ {
+		year := uint16(yearFrom + s - first)
+		for i := idx.starts[s]; i < idx.starts[s+1]; i++ {
+			res = append(res, WeekYearDeaths{Week: idx.weeks[i], Year: year, Deaths: idx.deaths[i]})
+		}
+	}
+
+	return res
+}
+
+// dimensions returns values of dimensions present in the index.
+func (idx *seriesIndex) dimensions() Dimensions {
+	dims := Dimensions{
+		Countries: append(make([]string, 0), idx.countries.values...),
+		Ages:      append(make([]string, 0), idx.ages.values...),
+		Genders:   append(make([]string, 0), idx.genders.values...),
+	}
+	if idx.years > 0 {
+		dims.YearFrom = idx.yearFrom
+		dims.YearTo = idx.yearFrom + idx.years - 1
+	}
+	return dims
+}
+
+// data rebuilds data keyed with makeKey from the index.
+func (idx *seriesIndex) data() map[string][]WeeklyDeaths {
+	data := make(map[string][]WeeklyDeaths)
+
+	for c, country := range idx.countries.values {
+		for a, age := range idx.ages.values {
+			for g, gender := range idx.genders.values {
+				for y := 0; y < idx.years; y++ {
+					s := idx.slot(c, a, g, y)
+					from, to := idx.starts[s], idx.starts[s+1]
+					if from == to {
+						continue
+					}
+
+					values := make([]WeeklyDeaths, 0, to-from)
+					for i := from; i < to; i++ {
+						values = append(values, WeeklyDeaths{Week: idx.weeks[i], Deaths: idx.deaths[i], Flags: idx.flags[i]})
+					}
+
+					key, _ := makeKey(country, gender, age, idx.yearFrom+y)
+					data[key] = values
+				}
+			}
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,141 @@
+package eurostat
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	benchmarkYearFrom = 2000
+	benchmarkYearTo   = 2023
+)
+
+var (
+	benchmarkSnapshotOnce sync.Once
+	benchmarkSnapshotData DataSnapshot
+)
+
+// generateSnapshot creates a snapshot of the size of the full Eurostat dataset.
+func generateSnapshot() DataSnapshot {
+	ds := DataSnapshot{Data: make(map[string][]WeeklyDeaths), Timestamp: time.Now().UTC()}
+
+	for c := 0; c < 38; c++ {
+		for a := 0; a < 21; a++ {
+			for _, gender := range []string{"T", "F", "M"} {
+				for year := benchmarkYearFrom; year <= benchmarkYearTo; year++ {
+					values := make([]WeeklyDeaths, 0, maxIsoWeekNum-1)
+					for w := 1; w < maxIsoWeekNum; w++ {
+						values = append(values, WeeklyDeaths{Week: uint8(w), Deaths: uint32(c*a + w)})
+					}
+					key, _ := makeKey(fmt.Sprintf("C%02d", c), gender, fmt.Sprintf("A%02d", a), year)
+					ds.Data[key] = values
+				}
+			}
+		}
+	}
+
+	return ds
+}
+
+func benchmarkSnapshot() DataSnapshot {
+	benchmarkSnapshotOnce.Do(func() {
+		benchmarkSnapshotData = generateSnapshot()
+	})
+	return benchmarkSnapshotData
+}
+
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+func TestSeriesIndexRoundTrip(t *testing.T) {
+	data := conformanceSnapshot().Data
+	idx, err := newSeriesIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := idx.data(); !reflect.DeepEqual(data, got) {
+		t.Fatalf("expected %+v but got %+v", data, got)
+	}
+
+	got := idx.weeklyDeaths("PL", "TOTAL", "T", 2021, 2030)
+	want := []WeekYearDeaths{{Week: 1, Year: 2021, Deaths: 3}, {Week: 2, Year: 2021, Deaths: 0}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+
+	if got := idx.weeklyDeaths("PL", "Y_LT5", "T", 2019, 2021); len(got) != 0 {
+		t.Fatalf("expected no values for empty series but got %+v", got)
+	}
+}
+
+func BenchmarkFootprintMap(b *testing.B) {
+	var ds DataSnapshot
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		ds = generateSnapshot()
+		b.ReportMetric(float64(heapInUse()-before)/(1<<20), "MiB")
+	}
+	runtime.KeepAlive(ds)
+}
+
+func BenchmarkFootprintIndex(b *testing.B) {
+	ds := benchmarkSnapshot()
+
+	var idx *seriesIndex
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		idx, _ = newSeriesIndex(ds.Data)
+		b.ReportMetric(float64(heapInUse()-before)/(1<<20), "MiB")
+	}
+	runtime.KeepAlive(idx)
+}
+
+func BenchmarkQueryFullRangeMap(b *testing.B) {
+	ds := benchmarkSnapshot()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = WeeklyDeathsFromSnapshot(ds, "C10", "A05", "T", benchmarkYearFrom, benchmarkYearTo)
+	}
+}
+
+func BenchmarkQueryFullRangeIndex(b *testing.B) {
+	db := DBFromSnapshot(benchmarkSnapshot())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _, _ = db.GetWeeklyDeaths("C10", "A05", "T", benchmarkYearFrom, benchmarkYearTo)
+	}
+}
+
+// multi-series benchmarks fetch the last five years of every age group of a country
+func BenchmarkQueryMultiSeriesMap(b *testing.B) {
+	ds := benchmarkSnapshot()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for a := 0; a < 21; a++ {
+			_, _ = WeeklyDeathsFromSnapshot(ds, "C10", fmt.Sprintf("A%02d", a), "F", benchmarkYearTo-4, benchmarkYearTo)
+		}
+	}
+}
+
+func BenchmarkQueryMultiSeriesIndex(b *testing.B) {
+	db := DBFromSnapshot(benchmarkSnapshot())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for a := 0; a < 21; a++ {
+			_, _, _ = db.GetWeeklyDeaths("C10", fmt.Sprintf("A%02d", a), "F", benchmarkYearTo-4, benchmarkYearTo)
+		}
+	}
+}
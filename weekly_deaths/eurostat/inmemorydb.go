@@ -1,23 +1,148 @@
 package eurostat
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"sync"
+	"log"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
+// dbSnapshot is an immutable version of data served by InMemoryDB.
+//
+// It's reference counted: InMemoryDB, the history cache and queries in
+// progress each hold a reference, and once the last one is released,
+// resources the index is read from (see mappedSnapshots) are closed.
+type dbSnapshot struct {
+	index     *seriesIndex
+	timestamp time.Time
+	// hash of the raw data mapped snapshot was created from
+	sourceHash [sha256.Size]byte
+
+	refs    atomic.Int32
+	onClose func()
+}
+
+// newIndexedSnapshot returns snapshot of the index with a single
+// reference held by the caller.
+func newIndexedSnapshot(idx *seriesIndex, timestamp time.Time) *dbSnapshot {
+	s := &dbSnapshot{index: idx, timestamp: timestamp}
+	s.refs.Store(1)
+	return s
+}
+
+func newDBSnapshot(snapshot DataSnapshot) (*dbSnapshot, error) {
+	idx, err := newSeriesIndex(snapshot.Data)
+	if err != nil {
+		return nil, fmt.Errorf("indexing snapshot: %w", err)
+	}
+
+	return newIndexedSnapshot(idx, snapshot.Timestamp), nil
+}
+
+// acquire takes a reference to the snapshot; it fails if the snapshot
+// was already closed.
+func (s *dbSnapshot) acquire() bool {
+	for {
+		n := s.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if s.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference, closing the snapshot once none are left.
+func (s *dbSnapshot) release() {
+	if s.refs.Add(-1) == 0 && s.onClose != nil {
+		s.onClose()
+	}
+}
+
+func (s *dbSnapshot) weeklyDeaths(country string, age string, gender string, yearFrom int, yearTo int) ([]WeekYearDeaths, error) {
+	if country == "" || age == "" || gender == "" {
+		return make([]WeekYearDeaths, 0), fmt.Errorf("fetching data from provider: %w", ErrEmptyDimension)
+	}
+
+	return s.index.weeklyDeaths(country, age, gender, yearFrom, yearTo), nil
+}
+
+func (s *dbSnapshot) dataSnapshot() DataSnapshot {
+	return DataSnapshot{Data: s.index.data(), Timestamp: s.timestamp}
+}
+
+// InMemoryDB is a Store keeping the data in application's memory,
+// in a compact columnar index (see seriesIndex). Loaded data is never
+// modified; LoadSnapshot atomically swaps it for a new version, so each
+// query is served from a single, consistent snapshot without locking.
+//
+// Created with MappedDBFromSnapshot, it keeps the index in files mapped
+// into memory instead of the heap.
 type InMemoryDB struct {
-	dataMu sync.RWMutex
-	data   map[string][]WeeklyDeaths
+	current atomic.Pointer[dbSnapshot]
+
+	history *snapshotHistory
 
-	dataTimestampMu sync.RWMutex
-	dataTimestamp   time.Time
+	// mapped snapshot files (nil if the index is kept on the heap)
+	mapped *mappedSnapshots
 }
 
 func DBFromSnapshot(snapshot DataSnapshot) *InMemoryDB {
-	return &InMemoryDB{
-		data:          snapshot.Data,
-		dataTimestamp: snapshot.Timestamp,
+	db := &InMemoryDB{}
+	db.current.Store(newIndexedSnapshot(&seriesIndex{}, time.Time{}))
+
+	err := db.loadSnapshot(snapshot)
+	if err != nil {
+		log.Printf("Loading snapshot into in-memory db failed: %s\n", err)
+	}
+	return db
+}
+
+// MappedDBFromSnapshot creates InMemoryDB serving the data from files
+// in the mapped snapshot format kept in dir (see writeMappedSnapshot).
+// Pages of mapped files are loaded on demand and can be reclaimed by
+// the OS, so the process footprint stays small even with several
+// vintages loaded for as_of queries. Files of snapshots that are no
+// longer live nor cached are deleted, including ones left in dir
+// by previous runs.
+func MappedDBFromSnapshot(dir string, snapshot DataSnapshot) (*InMemoryDB, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &InMemoryDB{mapped: newMappedSnapshots(dir)}
+	err = db.loadSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.mapped.removeUnused()
+	if err != nil {
+		log.Printf("Removing unused mapped snapshots failed: %s\n", err)
+	}
+	return db, nil
+}
+
+func (db *InMemoryDB) newSnapshot(snapshot DataSnapshot) (*dbSnapshot, error) {
+	if db.mapped != nil {
+		return db.mapped.open(snapshot)
+	}
+	return newDBSnapshot(snapshot)
+}
+
+// acquireCurrent returns the live snapshot with a reference taken,
+// which is released once the caller is done reading it.
+func (db *InMemoryDB) acquireCurrent() *dbSnapshot {
+	for {
+		// a snapshot closed after it was swapped out is replaced already
+		s := db.current.Load()
+		if s.acquire() {
+			return s
+		}
 	}
 }
 
@@ -27,45 +152,137 @@ func (db *InMemoryDB) GetWeeklyDeaths(
 	gender string,
 	yearFrom int,
 	yearTo int,
-) ([]WeekYearDeaths, error) {
-	res := make([]WeekYearDeaths, 0)
+) ([]WeekYearDeaths, time.Time, error) {
+	current := db.acquireCurrent()
+	defer current.release()
+
+	res, err := current.weeklyDeaths(country, age, gender, yearFrom, yearTo)
+	return res, current.timestamp, err
+}
+
+// EnableHistory allows querying older snapshots (see SnapshotAsOf).
+// Up to cacheSize most recently used older snapshots are kept in memory,
+// others are loaded lazily from the source.
+func (db *InMemoryDB) EnableHistory(source SnapshotSource, cacheSize int) {
+	db.history = newSnapshotHistory(source, cacheSize, db.newSnapshot)
+}
+
+func (db *InMemoryDB) LoadSnapshot(snapshot DataSnapshot) error {
+	return db.loadSnapshot(snapshot)
+}
 
-	years := makeRange(yearFrom, yearTo)
-	if len(years) == 0 {
-		return res, nil
+// loadSnapshot swaps the live snapshot for the new one; the replaced one
+// is kept in the history cache (if history is enabled) and closed once
+// queries still reading it are done.
+func (db *InMemoryDB) loadSnapshot(snapshot DataSnapshot) error {
+	s, err := db.newSnapshot(snapshot)
+	if err != nil {
+		return err
 	}
 
-	for _, year := range years {
-		key, err := makeKey(country, gender, age, year)
-		if err != nil {
-			return res, fmt.Errorf("fetching data from provider: %w", err)
-		}
+	old := db.current.Swap(s)
+	if old == nil {
+		return nil
+	}
+	if db.history != nil {
+		db.history.remember(old)
+	}
+	old.release()
+	return nil
+}
 
-		db.dataMu.RLock()
-		for _, r := range db.data[key] {
-			res = append(res, WeekYearDeaths{Week: r.Week, Deaths: r.Deaths, Year: uint16(year)})
-		}
-		db.dataMu.RUnlock()
+func (db *InMemoryDB) Timestamp() time.Time {
+	return db.current.Load().timestamp
+}
+
+// Snapshot returns currently loaded data along with its timestamp.
+// Data is rebuilt from the index, so it's a relatively expensive call.
+func (db *InMemoryDB) Snapshot() (DataSnapshot, error) {
+	current := db.acquireCurrent()
+	defer current.release()
+
+	return current.dataSnapshot(), nil
+}
+
+// Dimensions returns values of dimensions present in the loaded snapshot.
+func (db *InMemoryDB) Dimensions() (Dimensions, error) {
+	current := db.acquireCurrent()
+	defer current.release()
+
+	return current.index.dimensions(), nil
+}
+
+// snapshotAsOf returns the most recent version of data that is not later
+// than asOf: the currently loaded one or (if history is enabled) an older one.
+// The returned snapshot is acquired and has to be released after use.
+func (db *InMemoryDB) snapshotAsOf(asOf time.Time) (*dbSnapshot, error) {
+	current := db.acquireCurrent()
+	if !current.timestamp.After(asOf) {
+		return current, nil
 	}
+	current.release()
 
-	return res, nil
+	if db.history == nil {
+		return nil, ErrNoSnapshotAsOf
+	}
+
+	return db.history.snapshotAsOf(asOf)
 }
 
-func (db *InMemoryDB) LoadSnapshot(snapshot DataSnapshot) {
-	db.dataMu.Lock()
-	db.dataTimestampMu.Lock()
+// SnapshotAsOf returns the most recent snapshot that is not later than asOf:
+// the currently loaded one or (if history is enabled) an older stored one.
+func (db *InMemoryDB) SnapshotAsOf(asOf time.Time) (DataSnapshot, error) {
+	s, err := db.snapshotAsOf(asOf)
+	if err != nil {
+		return DataSnapshot{}, err
+	}
+	defer s.release()
+
+	return s.dataSnapshot(), nil
+}
 
-	db.data = snapshot.Data
-	db.dataTimestamp = snapshot.Timestamp
+// GetWeeklyDeathsAsOf works like GetWeeklyDeaths, serving the data
+// from the snapshot returned by SnapshotAsOf.
+func (db *InMemoryDB) GetWeeklyDeathsAsOf(
+	asOf time.Time,
+	country string,
+	age string,
+	gender string,
+	yearFrom int,
+	yearTo int,
+) ([]WeekYearDeaths, time.Time, error) {
+	s, err := db.snapshotAsOf(asOf)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer s.release()
 
-	db.dataMu.Unlock()
-	db.dataTimestampMu.Unlock()
+	res, err := s.weeklyDeaths(country, age, gender, yearFrom, yearTo)
+	return res, s.timestamp, err
 }
 
-func (db *InMemoryDB) Timestamp() time.Time {
-	db.dataTimestampMu.RLock()
-	ts := db.dataTimestamp
-	db.dataTimestampMu.RUnlock()
+// WeeklyDeathsFromSnapshot returns weekly deaths for given series and
+// range of years from the snapshot.
+func WeeklyDeathsFromSnapshot(
+	snapshot DataSnapshot,
+	country string,
+	age string,
+	gender string,
+	yearFrom int,
+	yearTo int,
+) ([]WeekYearDeaths, error) {
+	res := make([]WeekYearDeaths, 0)
+
+	for _, year := range makeRange(yearFrom, yearTo) {
+		key, err := makeKey(country, gender, age, year)
+		if err != nil {
+			return res, fmt.Errorf("fetching data from snapshot: %w", err)
+		}
 
-	return ts
+		for _, r := range snapshot.Data[key] {
+			res = append(res, WeekYearDeaths{Week: r.Week, Deaths: r.Deaths, Year: uint16(year)})
+		}
+	}
+
+	return res, nil
 }
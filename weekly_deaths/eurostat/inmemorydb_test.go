@@ -0,0 +1,64 @@
+package eurostat
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// consistentSnapshot creates snapshot in which all values equal version.
+func consistentSnapshot(version int) DataSnapshot {
+	ds := DataSnapshot{
+		Data:      make(map[string][]WeeklyDeaths),
+		Timestamp: time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC).Add(time.Duration(version) * time.Hour),
+	}
+	for year := 2000; year <= 2020; year++ {
+		key, _ := makeKey("PL", "T", "TOTAL", year)
+		for w := 1; w < maxIsoWeekNum; w++ {
+			ds.Data[key] = append(ds.Data[key], WeeklyDeaths{Week: uint8(w), Deaths: uint32(version)})
+		}
+	}
+	return ds
+}
+
+func TestInMemoryDBQueriesReadSingleSnapshot(t *testing.T) {
+	const versions = 20
+
+	snapshots := make([]DataSnapshot, versions)
+	for v := range snapshots {
+		snapshots[v] = consistentSnapshot(v)
+	}
+
+	db := DBFromSnapshot(snapshots[0])
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for v := 1; v < versions; v++ {
+			_ = db.LoadSnapshot(snapshots[v])
+		}
+		close(done)
+	}()
+
+	for {
+		res, ts, err := db.GetWeeklyDeaths("PL", "TOTAL", "T", 2000, 2020)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version := uint32(ts.Sub(snapshots[0].Timestamp) / time.Hour)
+		for _, r := range res {
+			if r.Deaths != version {
+				t.Fatalf("expected all values from snapshot %d but got %+v", version, r)
+			}
+		}
+
+		select {
+		case <-done:
+			wg.Wait()
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package eurostat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseKey is the key of the lease object in the snapshot store.
+const DefaultLeaseKey = "leader.lease.json"
+
+// DefaultLeaseTTL is the time a lease is held for without renewal.
+const DefaultLeaseTTL = time.Minute
+
+// leaseRecord is the content of the lease object.
+type leaseRecord struct {
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LeaseStatus describes the current holder of the lease.
+type LeaseStatus struct {
+	Instance  string    `json:"instance"`
+	Leader    bool      `json:"leader"`
+	Holder    string    `json:"holder,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Lease elects a single leader among instances sharing the snapshot store.
+// The lease object is written with conditional writes, so only one instance
+// can acquire it; the holder has to renew it before it expires.
+type Lease struct {
+	store  ConditionalSnapshotStore
+	key    string
+	holder string
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	expires time.Time
+	status  LeaseStatus
+}
+
+// NewLease creates lease held by holder (unique name of the instance).
+// The store has to support conditional writes.
+func NewLease(store SnapshotStore, key string, holder string, ttl time.Duration) (*Lease, error) {
+	cs, ok := store.(ConditionalSnapshotStore)
+	if !ok {
+		return nil, ErrConditionalWritesNotSupported
+	}
+
+	return &Lease{
+		store:  cs,
+		key:    key,
+		holder: holder,
+		ttl:    ttl,
+		now:    time.Now,
+		status: LeaseStatus{Instance: holder},
+	}, nil
+}
+
+// NewLease creates lease in the manager's snapshot store.
+func (sm *SnapshotManager) NewLease(holder string, ttl time.Duration) (*Lease, error) {
+	return NewLease(sm.store, DefaultLeaseKey, holder, ttl)
+}
+
+// TryAcquire acquires the lease if it's free or expired, or renews it
+// if it's already held. It returns whether this instance is the leader.
+func (l *Lease) TryAcquire() (bool, error) {
+	now := l.now().UTC()
+	record, version, err := l.read()
+	if err != nil {
+		l.setStatus(time.Time{}, LeaseStatus{Error: err.Error()})
+		return false, err
+	}
+
+	if version != "" && record.Holder != l.holder && now.Before(record.ExpiresAt) {
+		l.setStatus(time.Time{}, LeaseStatus{Holder: record.Holder, ExpiresAt: record.ExpiresAt})
+		return false, nil
+	}
+
+	acquired := now
+	if version != "" && record.Holder == l.holder {
+		acquired = record.AcquiredAt
+	}
+	next := leaseRecord{Holder: l.holder, AcquiredAt: acquired, ExpiresAt: now.Add(l.ttl)}
+	b, err := json.Marshal(next)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = l.store.PutIf(l.key, b, version)
+	if errors.Is(err, ErrPreconditionFailed) {
+		// another instance was faster
+		l.setStatus(time.Time{}, LeaseStatus{Holder: record.Holder, ExpiresAt: record.ExpiresAt})
+		return false, nil
+	}
+	if err != nil {
+		l.setStatus(time.Time{}, LeaseStatus{Error: err.Error()})
+		return false, err
+	}
+
+	l.setStatus(next.ExpiresAt, LeaseStatus{Leader: true, Holder: l.holder, ExpiresAt: next.ExpiresAt})
+	return true, nil
+}
+
+// read returns the lease record and its version (empty if there's none).
+func (l *Lease) read() (leaseRecord, string, error) {
+	var record leaseRecord
+
+	b, version, err := l.store.GetWithVersion(l.key)
+	if errors.Is(err, ErrSnapshotObjectNotFound) {
+		return record, "", nil
+	}
+	if err != nil {
+		return record, "", err
+	}
+
+	err = json.Unmarshal(b, &record)
+	if err != nil {
+		return record, "", fmt.Errorf("parsing lease %s: %w", l.key, err)
+	}
+	return record, version, nil
+}
+
+func (l *Lease) setStatus(expires time.Time, status LeaseStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status.Instance = l.holder
+	l.expires = expires
+	l.status = status
+}
+
+// Release gives up the lease (if held), so another instance
+// can take over without waiting for it to expire.
+func (l *Lease) Release() error {
+	record, version, err := l.read()
+	if err != nil || version == "" || record.Holder != l.holder {
+		return err
+	}
+
+	record.ExpiresAt = l.now().UTC()
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.store.PutIf(l.key, b, version)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	l.setStatus(time.Time{}, LeaseStatus{Holder: record.Holder, ExpiresAt: record.ExpiresAt})
+	return nil
+}
+
+// IsLeader reports whether this instance holds the lease that hasn't expired.
+func (l *Lease) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.now().Before(l.expires)
+}
+
+// Status returns the outcome of the latest attempt to acquire the lease.
+func (l *Lease) Status() LeaseStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := l.status
+	status.Leader = l.now().Before(l.expires)
+	return status
+}
@@ -0,0 +1,148 @@
+package eurostat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConditionalSnapshotStore(t *testing.T) {
+	for name, newStore := range snapshotStoreBackends {
+		t.Run(name, func(t *testing.T) {
+			store, ok := newStore(t).(ConditionalSnapshotStore)
+			if !ok {
+				t.Fatal("expected store to support conditional writes")
+			}
+
+			_, _, err := store.GetWithVersion("lease.json")
+			if !errors.Is(err, ErrSnapshotObjectNotFound) {
+				t.Fatalf("expected ErrSnapshotObjectNotFound but got %v", err)
+			}
+			_, err = store.PutIf("lease.json", []byte("a"), "missing")
+			if !errors.Is(err, ErrPreconditionFailed) {
+				t.Fatalf("expected ErrPreconditionFailed for missing object but got %v", err)
+			}
+
+			version, err := store.PutIf("lease.json", []byte("a"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = store.PutIf("lease.json", []byte("b"), "")
+			if !errors.Is(err, ErrPreconditionFailed) {
+				t.Fatalf("expected ErrPreconditionFailed for existing object but got %v", err)
+			}
+
+			data, got, err := store.GetWithVersion("lease.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "a" || got != version {
+				t.Fatalf("expected %q with version %s but got %q with version %s", "a", version, data, got)
+			}
+
+			next, err := store.PutIf("lease.json", []byte("b"), version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = store.PutIf("lease.json", []byte("c"), version)
+			if !errors.Is(err, ErrPreconditionFailed) {
+				t.Fatalf("expected ErrPreconditionFailed for stale version but got %v", err)
+			}
+
+			data, got, err = store.GetWithVersion("lease.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "b" || got != next {
+				t.Fatalf("expected %q with version %s but got %q with version %s", "b", next, data, got)
+			}
+
+			keys, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 || keys[0] != "lease.json" {
+				t.Fatalf("expected only the lease to be listed but got %v", keys)
+			}
+		})
+	}
+}
+
+func TestLease(t *testing.T) {
+	for name, newStore := range snapshotStoreBackends {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+			clock := func() time.Time { return now }
+
+			newLease := func(holder string) *Lease {
+				l, err := NewLease(store, DefaultLeaseKey, holder, time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				l.now = clock
+				return l
+			}
+			a, b := newLease("a"), newLease("b")
+
+			acquire := func(l *Lease, want bool) {
+				t.Helper()
+				got, err := l.TryAcquire()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want || l.IsLeader() != want {
+					t.Fatalf("%s: expected leader %t but got %t", l.holder, want, got)
+				}
+			}
+
+			acquire(a, true)
+			acquire(b, false)
+			if status := b.Status(); status.Holder != "a" || status.Leader || status.Instance != "b" {
+				t.Fatalf("expected lease to be held by a but got %+v", status)
+			}
+
+			// renewal extends the lease
+			now = now.Add(50 * time.Second)
+			acquire(a, true)
+			now = now.Add(50 * time.Second)
+			acquire(b, false)
+			if !a.IsLeader() {
+				t.Fatal("expected renewed lease to be held")
+			}
+
+			// expired lease is taken over
+			now = now.Add(time.Minute)
+			if a.IsLeader() {
+				t.Fatal("expected lease to expire without renewal")
+			}
+			acquire(b, true)
+			acquire(a, false)
+
+			// released lease is free immediately
+			err := b.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.IsLeader() {
+				t.Fatal("expected released lease not to be held")
+			}
+			acquire(a, true)
+
+			// releasing lease held by another instance is no-op
+			err = b.Release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			acquire(b, false)
+		})
+	}
+}
+
+func TestLeaseRequiresConditionalWrites(t *testing.T) {
+	var store struct{ SnapshotStore }
+	_, err := NewLease(store, DefaultLeaseKey, "a", time.Minute)
+	if !errors.Is(err, ErrConditionalWritesNotSupported) {
+		t.Fatalf("expected ErrConditionalWritesNotSupported but got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package eurostat
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const manifestExtension = ".manifest.json"
+
+// ParseReport summarizes the data parsed from a raw snapshot.
+type ParseReport struct {
+	Series      int `json:"series"`
+	Values      int `json:"values"`
+	Missing     int `json:"missing"`
+	Provisional int `json:"provisional"`
+}
+
+// SnapshotManifest describes a stored snapshot. Snapshots are identified
+// by SHA-256 of their raw content, so a refresh that brings the same content
+// as the latest stored snapshot only updates its CheckedAt.
+type SnapshotManifest struct {
+	Timestamp    time.Time   `json:"timestamp"`
+	SHA256       string      `json:"sha256"`
+	Size         int         `json:"size"`
+	SourceURL    string      `json:"source_url,omitempty"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Rows         int         `json:"rows"`
+	ParseReport  ParseReport `json:"parse_report"`
+	FetchedAt    time.Time   `json:"fetched_at"`
+	CheckedAt    time.Time   `json:"checked_at"`
+}
+
+// NewParseReport counts series and values of parsed data.
+func NewParseReport(data map[string][]WeeklyDeaths) ParseReport {
+	report := ParseReport{Series: len(data)}
+	for _, values := range data {
+		report.Values += len(values)
+		for _, v := range values {
+			if v.Flags&FlagMissing != 0 {
+				report.Missing++
+			}
+			if v.Flags&FlagProvisional != 0 {
+				report.Provisional++
+			}
+		}
+	}
+	return report
+}
+
+// countRows returns the number of rows of raw data (series across all years).
+func countRows(data map[string][]WeeklyDeaths) int {
+	rows := make(map[Metadata]struct{})
+	for key := range data {
+		metadata, _, err := parseKey(key)
+		if err == nil {
+			rows[metadata] = struct{}{}
+		}
+	}
+	return len(rows)
+}
+
+// NewSnapshotManifest describes the snapshot parsed from raw data
+// downloaded from sourceURL; header holds the response headers (may be nil).
+func NewSnapshotManifest(raw []byte, snapshot DataSnapshot, sourceURL string, header http.Header) SnapshotManifest {
+	hash := SourceHash(raw)
+	return SnapshotManifest{
+		Timestamp:    snapshot.Timestamp,
+		SHA256:       hex.EncodeToString(hash[:]),
+		Size:         len(raw),
+		SourceURL:    sourceURL,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Rows:         countRows(snapshot.Data),
+		ParseReport:  NewParseReport(snapshot.Data),
+		FetchedAt:    snapshot.Timestamp,
+		CheckedAt:    snapshot.Timestamp,
+	}
+}
+
+func manifestKey(key string) string {
+	return strings.TrimSuffix(key, dataFileExtension) + manifestExtension
+}
+
+func (sm *SnapshotManager) putManifest(manifest SnapshotManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return sm.store.Put(snapshotKey(manifest.Timestamp, manifestExtension), b, nil)
+}
+
+// manifest returns manifest of the raw snapshot stored under key. Snapshots
+// persisted without a manifest get one with fields known from the store.
+func (sm *SnapshotManager) manifest(key string) (SnapshotManifest, error) {
+	var manifest SnapshotManifest
+
+	b, err := sm.store.Get(manifestKey(key))
+	if err == nil {
+		err = json.Unmarshal(b, &manifest)
+		if err != nil {
+			return manifest, fmt.Errorf("parsing manifest of %s: %w", key, err)
+		}
+		return manifest, nil
+	}
+	if !errors.Is(err, ErrSnapshotObjectNotFound) {
+		return manifest, err
+	}
+
+	ts, err := parseTimestamp(key)
+	if err != nil {
+		return manifest, err
+	}
+
+	metadata, err := sm.store.Head(key)
+	if err != nil {
+		return manifest, err
+	}
+
+	return SnapshotManifest{
+		Timestamp: ts,
+		SHA256:    metadata[sourceHashMetadataKey],
+		FetchedAt: ts,
+		CheckedAt: ts,
+	}, nil
+}
+
+// LatestManifest returns manifest of the latest stored snapshot.
+func (sm *SnapshotManager) LatestManifest() (SnapshotManifest, error) {
+	keys, err := sm.listSnapshotsChronologically()
+	if err != nil {
+		return SnapshotManifest{}, err
+	}
+
+	return sm.manifest(keys[len(keys)-1])
+}
+
+// StoreSnapshot persists raw snapshot data, its binary version and manifest,
+// unless the content is the same as the latest stored snapshot's. In that
+// case only CheckedAt (and upstream headers) of the latest manifest are
+// updated. It returns whether a new snapshot was stored.
+func (sm *SnapshotManager) StoreSnapshot(raw []byte, snapshot DataSnapshot, manifest SnapshotManifest) (bool, error) {
+	latest, err := sm.LatestManifest()
+	if err != nil && !errors.Is(err, ErrNoParsableObjectsInBucket) {
+		return false, fmt.Errorf("reading latest manifest: %w", err)
+	}
+
+	if err == nil && latest.SHA256 != "" && latest.SHA256 == manifest.SHA256 {
+		latest.CheckedAt = manifest.CheckedAt
+		if manifest.ETag != "" {
+			latest.ETag = manifest.ETag
+		}
+		if manifest.LastModified != "" {
+			latest.LastModified = manifest.LastModified
+		}
+		return false, sm.putManifest(latest)
+	}
+
+	err = sm.PersistSnapshot(bytes.NewReader(raw), snapshot.Timestamp)
+	if err != nil {
+		return false, err
+	}
+
+	err = sm.PersistBinarySnapshot(snapshot, SourceHash(raw))
+	if err != nil {
+		log.Printf("Failed to persist binary snapshot: %s\n", err)
+	}
+
+	return true, sm.putManifest(manifest)
+}
+
+// StoreNewSnapshot works like StoreSnapshot, but never replaces a stored
+// snapshot (the pinned one included): it fails with ErrSnapshotExists
+// if there's already a snapshot with the same timestamp.
+func (sm *SnapshotManager) StoreNewSnapshot(raw []byte, snapshot DataSnapshot, manifest SnapshotManifest) (bool, error) {
+	err := sm.snapshotExists(snapshot.Timestamp)
+	if err == nil {
+		return false, fmt.Errorf("%w: %s", ErrSnapshotExists, snapshot.Timestamp.Format(timestampLayout))
+	}
+	if !errors.Is(err, ErrSnapshotNotFound) {
+		return false, err
+	}
+
+	return sm.StoreSnapshot(raw, snapshot, manifest)
+}
+
+// RecordCheck updates CheckedAt of the latest stored snapshot's manifest,
+// after upstream reported that there's no new data.
+func (sm *SnapshotManager) RecordCheck(checkedAt time.Time) error {
+	latest, err := sm.LatestManifest()
+	if err != nil {
+		return err
+	}
+
+	latest.CheckedAt = checkedAt
+	return sm.putManifest(latest)
+}
@@ -0,0 +1,113 @@
+package eurostat
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewParseReport(t *testing.T) {
+	data := map[string][]WeeklyDeaths{
+		"PL|2021|TOTAL|T": {{Week: 1, Deaths: 10}, {Week: 2, Flags: FlagMissing}},
+		"PL|2022|TOTAL|T": {{Week: 1, Deaths: 10, Flags: FlagProvisional}},
+		"DE|2022|TOTAL|T": {{Week: 1, Deaths: 10}},
+	}
+
+	want := ParseReport{Series: 3, Values: 4, Missing: 1, Provisional: 1}
+	if got := NewParseReport(data); got != want {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+	if got := countRows(data); got != 2 {
+		t.Fatalf("expected 2 rows but got %d", got)
+	}
+}
+
+func TestStoreSnapshotSkipsDuplicates(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := NewMemorySnapshotStore()
+	sm := NewSnapshotManagerWithStore(store)
+
+	storeRaw := func(raw []byte, ts time.Time, etag string) bool {
+		t.Helper()
+
+		data, err := parseRawSnapshot(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds := DataSnapshot{Data: data, Timestamp: ts}
+		header := http.Header{"Etag": []string{etag}}
+
+		stored, err := sm.StoreSnapshot(raw, ds, NewSnapshotManifest(raw, ds, eurostatDataUrl, header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+
+	first := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W02\t2021W01\nTOTAL,T,NR,PL\t2 p\t:")
+	second := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W02\t2021W01\nTOTAL,T,NR,PL\t3\t1")
+
+	if !storeRaw(first, ts, `"a"`) {
+		t.Fatal("expected the first snapshot to be stored")
+	}
+	if storeRaw(first, ts.Add(time.Hour), `"b"`) {
+		t.Fatal("expected unchanged snapshot not to be stored")
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"20230102T030405.manifest.json", "20230102T030405.snap", "20230102T030405.tsv.gz"}
+	if !reflect.DeepEqual(want, keys) {
+		t.Fatalf("expected keys %v but got %v", want, keys)
+	}
+
+	manifest, err := sm.LatestManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.FetchedAt.Equal(ts) || !manifest.CheckedAt.Equal(ts.Add(time.Hour)) || manifest.ETag != `"b"` {
+		t.Fatalf("expected check time and ETag to be updated but got %+v", manifest)
+	}
+	if want := (ParseReport{Series: 1, Values: 2, Missing: 1, Provisional: 1}); manifest.ParseReport != want || manifest.Rows != 1 {
+		t.Fatalf("expected parse report %+v but got %+v", want, manifest)
+	}
+	if manifest.SourceURL != eurostatDataUrl || manifest.Size != len(first) {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	if !storeRaw(second, ts.Add(2*time.Hour), `"c"`) {
+		t.Fatal("expected changed snapshot to be stored")
+	}
+	manifest, err = sm.LatestManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Timestamp.Equal(ts.Add(2 * time.Hour)) {
+		t.Fatalf("expected manifest of the new snapshot but got %+v", manifest)
+	}
+}
+
+func TestLatestManifestOfSnapshotWithoutManifest(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	sm := NewSnapshotManagerWithStore(NewMemorySnapshotStore())
+
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+	err := sm.PersistSnapshot(bytes.NewReader(raw), ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := sm.LatestManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := SourceHash(raw)
+	if want := hex.EncodeToString(hash[:]); manifest.SHA256 != want || !manifest.Timestamp.Equal(ts) {
+		t.Fatalf("expected manifest with hash %s but got %+v", want, manifest)
+	}
+}
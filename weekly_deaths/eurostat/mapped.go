@@ -0,0 +1,370 @@
+package eurostat
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Mapped snapshot format is an on-disk version of seriesIndex that is
+// memory-mapped and queried in place, without copying the columns to
+// the heap (all integers are little endian):
+//
+//	magic      [6]byte  "WDCOLS"
+//	version    uint16
+//	timestamp  int64    Unix nanoseconds
+//	source     [32]byte SHA-256 of the raw snapshot (see SourceHash), zero if unknown
+//	year from  int32
+//	years      uint32
+//	values     uint32   number of values (n)
+//	countries, ages, genders: uint32 count followed by values (uint16 length, bytes)
+//	padding to 4 bytes
+//	starts     [slots+1]uint32
+//	deaths     [n]uint32
+//	weeks      [n]uint8
+//	flags      [n]uint8
+//	checksum   uint32   CRC-32 (Castagnoli) of all preceding bytes
+const (
+	mappedSnapshotMagic   = "WDCOLS"
+	mappedSnapshotVersion = 2
+
+	mappedSnapshotExtension = ".col"
+)
+
+// nativeLittleEndian tells whether uint32 columns can be read from
+// the mapped file in place.
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// mappedFile is a read-only file mapped into memory. Columns of the index
+// point directly into the mapping, so it's closed only once the snapshot
+// is released by everyone reading it (see dbSnapshot).
+type mappedFile struct {
+	data []byte
+}
+
+func openMappedFile(path string) (*mappedFile, error) {
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappedFile{data: data}, nil
+}
+
+func (m *mappedFile) close() error {
+	return unmapFile(m.data)
+}
+
+func mappedSnapshotPath(dir string, timestamp time.Time) string {
+	return filepath.Join(dir, timestamp.UTC().Format(timestampLayout)+mappedSnapshotExtension)
+}
+
+// writeMappedSnapshot writes the index in mapped snapshot format. Data is
+// written to a temporary file first, so a partially written file is never mapped.
+func writeMappedSnapshot(path string, idx *seriesIndex, timestamp time.Time, sourceHash [sha256.Size]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = encodeMappedSnapshot(tmp, idx, timestamp, sourceHash)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func encodeMappedSnapshot(w io.Writer, idx *seriesIndex, timestamp time.Time, sourceHash [sha256.Size]byte) error {
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	buf := make([]byte, 0, 96)
+	buf = append(buf, mappedSnapshotMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, mappedSnapshotVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(timestamp.UnixNano()))
+	buf = append(buf, sourceHash[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(idx.yearFrom)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(idx.years))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(idx.weeks)))
+
+	for _, d := range []dimension{idx.countries, idx.ages, idx.genders} {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(d.values)))
+		for _, v := range d.values {
+			if len(v) > math.MaxUint16 {
+				return fmt.Errorf("dimension value %s too long for mapped snapshot", v)
+			}
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(len(v)))
+			buf = append(buf, v...)
+		}
+	}
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	_, _ = bw.Write(buf)
+
+	for _, col := range [][]uint32{idx.starts, idx.deaths} {
+		for _, v := range col {
+			buf = binary.LittleEndian.AppendUint32(buf[:0], v)
+			_, _ = bw.Write(buf)
+		}
+	}
+	_, _ = bw.Write(idx.weeks)
+	_, _ = bw.Write(idx.flags)
+
+	err := bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, crc.Sum32())
+}
+
+// uint32Column returns little endian encoded values as a slice,
+// referencing the bytes directly when possible.
+func uint32Column(b []byte) []uint32 {
+	n := len(b) / 4
+	if n == 0 {
+		return nil
+	}
+
+	if nativeLittleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), n)
+	}
+
+	col := make([]uint32, n)
+	for i := range col {
+		col[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return col
+}
+
+// decodeMappedSnapshot creates an index backed by the bytes of mapped snapshot
+// along with the hash of its source.
+func decodeMappedSnapshot(b []byte) (*seriesIndex, time.Time, [sha256.Size]byte, error) {
+	var (
+		ts         time.Time
+		sourceHash [sha256.Size]byte
+	)
+
+	if len(b) < len(mappedSnapshotMagic)+2+4 || string(b[:len(mappedSnapshotMagic)]) != mappedSnapshotMagic {
+		return nil, ts, sourceHash, ErrBadSnapshotFormat
+	}
+
+	if v := binary.LittleEndian.Uint16(b[len(mappedSnapshotMagic):]); v != mappedSnapshotVersion {
+		return nil, ts, sourceHash, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, v)
+	}
+
+	payload, checksum := b[:len(b)-4], binary.LittleEndian.Uint32(b[len(b)-4:])
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, ts, sourceHash, ErrSnapshotChecksumMismatch
+	}
+
+	br := &byteReader{b: payload, off: len(mappedSnapshotMagic) + 2}
+	ts = time.Unix(0, int64(br.uint64())).UTC()
+	copy(sourceHash[:], br.next(sha256.Size))
+
+	idx := &seriesIndex{
+		yearFrom: int(int32(br.uint32())),
+		years:    int(br.uint32()),
+	}
+	values := int(br.uint32())
+
+	for _, d := range []*dimension{&idx.countries, &idx.ages, &idx.genders} {
+		count := int(br.uint32())
+		if br.err != nil || count > len(payload) {
+			return nil, ts, sourceHash, fmt.Errorf("%w: bad dimension", ErrBadSnapshotFormat)
+		}
+
+		d.values = make([]string, 0, count)
+		d.ordinals = make(map[string]int, count)
+		for i := 0; i < count; i++ {
+			v := string(br.next(int(br.uint16())))
+			d.ordinals[v] = i
+			d.values = append(d.values, v)
+		}
+	}
+	br.next((4 - br.off%4) % 4)
+
+	slots := len(idx.countries.values) * len(idx.ages.values) * len(idx.genders.values) * idx.years
+	if br.err != nil || 4*(slots+1)+6*values != len(payload)-br.off {
+		return nil, ts, sourceHash, fmt.Errorf("%w: unexpected size of columns", ErrBadSnapshotFormat)
+	}
+
+	idx.starts = uint32Column(br.next(4 * (slots + 1)))
+	idx.deaths = uint32Column(br.next(4 * values))
+	idx.weeks = br.next(values)
+	idx.flags = br.next(values)
+	if idx.starts[slots] != uint32(values) {
+		return nil, ts, sourceHash, fmt.Errorf("%w: bad series offsets", ErrBadSnapshotFormat)
+	}
+
+	return idx, ts, sourceHash, nil
+}
+
+// openMappedSnapshot maps the snapshot file into memory; the file is
+// unmapped once the snapshot is released.
+func openMappedSnapshot(path string) (*dbSnapshot, error) {
+	m, err := openMappedFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, ts, sourceHash, err := decodeMappedSnapshot(m.data)
+	if err != nil {
+		_ = m.close()
+		return nil, fmt.Errorf("reading mapped snapshot %s: %w", path, err)
+	}
+	idx.mapping = m
+
+	s := newIndexedSnapshot(idx, ts)
+	s.sourceHash = sourceHash
+	s.onClose = func() {
+		err := m.close()
+		if err != nil {
+			log.Printf("Unmapping snapshot %s failed: %s\n", path, err)
+		}
+	}
+	return s, nil
+}
+
+// mappedSnapshots manages mapped snapshot files in a directory. A file
+// is deleted once no open snapshot maps it anymore.
+type mappedSnapshots struct {
+	dir string
+
+	mu     sync.Mutex
+	opened map[string]int
+}
+
+func newMappedSnapshots(dir string) *mappedSnapshots {
+	return &mappedSnapshots{dir: dir, opened: make(map[string]int)}
+}
+
+// open returns mapped version of the snapshot (see newMappedDBSnapshot),
+// deleting its file once the snapshot is closed, unless it's still open
+// in another snapshot.
+func (ms *mappedSnapshots) open(snapshot DataSnapshot) (*dbSnapshot, error) {
+	if snapshot.Timestamp.IsZero() {
+		return newDBSnapshot(snapshot)
+	}
+
+	// counted before the file is written, so it's not deleted meanwhile
+	path := mappedSnapshotPath(ms.dir, snapshot.Timestamp)
+	ms.mu.Lock()
+	ms.opened[path]++
+	ms.mu.Unlock()
+
+	s, err := newMappedDBSnapshot(ms.dir, snapshot)
+	if err != nil {
+		ms.closed(path)
+		return nil, err
+	}
+
+	unmap := s.onClose
+	s.onClose = func() {
+		unmap()
+		ms.closed(path)
+	}
+	return s, nil
+}
+
+func (ms *mappedSnapshots) closed(path string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.opened[path]--
+	if ms.opened[path] > 0 {
+		return
+	}
+	delete(ms.opened, path)
+
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Removing mapped snapshot %s failed: %s\n", path, err)
+	}
+}
+
+// removeUnused deletes mapped snapshot files that are not open
+// (e.g. left by previous runs).
+func (ms *mappedSnapshots) removeUnused() error {
+	paths, err := filepath.Glob(filepath.Join(ms.dir, "*"+mappedSnapshotExtension))
+	if err != nil {
+		return err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var errs []error
+	for _, path := range paths {
+		if ms.opened[path] > 0 {
+			continue
+		}
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newMappedDBSnapshot returns mapped version of the snapshot stored in dir,
+// writing it first if it isn't there yet. Files are named after snapshot
+// timestamps, so every vintage is written only once; an existing file is
+// reused only if it was created from the same raw data (different data
+// can be stored under the same timestamp, e.g. by uploads).
+func newMappedDBSnapshot(dir string, snapshot DataSnapshot) (*dbSnapshot, error) {
+	// there's nothing to map until any data is loaded (zero timestamp
+	// doesn't fit in the file format either)
+	if snapshot.Timestamp.IsZero() {
+		return newDBSnapshot(snapshot)
+	}
+
+	path := mappedSnapshotPath(dir, snapshot.Timestamp)
+
+	s, err := openMappedSnapshot(path)
+	if err == nil && s.timestamp.Equal(snapshot.Timestamp) &&
+		snapshot.SourceHash != ([sha256.Size]byte{}) && s.sourceHash == snapshot.SourceHash {
+		return s, nil
+	}
+	if err == nil {
+		s.release()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Mapped snapshot %s will be rewritten: %s\n", path, err)
+	}
+
+	idx, err := newSeriesIndex(snapshot.Data)
+	if err != nil {
+		return nil, fmt.Errorf("indexing snapshot: %w", err)
+	}
+
+	err = writeMappedSnapshot(path, idx, snapshot.Timestamp, snapshot.SourceHash)
+	if err != nil {
+		return nil, fmt.Errorf("writing mapped snapshot: %w", err)
+	}
+
+	return openMappedSnapshot(path)
+}
@@ -0,0 +1,280 @@
+package eurostat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMappedSnapshotRoundTrip(t *testing.T) {
+	snapshot := conformanceSnapshot()
+	snapshot.SourceHash = SourceHash([]byte("raw"))
+	idx, err := newSeriesIndex(snapshot.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.col")
+	err = writeMappedSnapshot(path, idx, snapshot.Timestamp, snapshot.SourceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openMappedSnapshot(path)
+	if err != nil {
+		t.Fatalf("Expected error to be nil but got %s\n", err)
+	}
+	if !s.timestamp.Equal(snapshot.Timestamp) {
+		t.Fatalf("expected timestamp %s but got %s", snapshot.Timestamp, s.timestamp)
+	}
+	if s.sourceHash != snapshot.SourceHash {
+		t.Fatalf("expected source hash %x but got %x", snapshot.SourceHash, s.sourceHash)
+	}
+	if got := s.index.data(); !reflect.DeepEqual(snapshot.Data, got) {
+		t.Fatalf("expected %+v but got %+v", snapshot.Data, got)
+	}
+	if want, got := idx.dimensions(), s.index.dimensions(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+}
+
+func TestMappedSnapshotChecksumMismatch(t *testing.T) {
+	idx, err := newSeriesIndex(conformanceSnapshot().Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.col")
+	err = writeMappedSnapshot(path, idx, conformanceSnapshot().Timestamp, SourceHash(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-6] ^= 0xff
+	err = os.WriteFile(path, b, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = openMappedSnapshot(path)
+	if !errors.Is(err, ErrSnapshotChecksumMismatch) {
+		t.Fatalf("expected %v but got %v", ErrSnapshotChecksumMismatch, err)
+	}
+}
+
+func TestMappedDBConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		db, err := MappedDBFromSnapshot(t.TempDir(), DataSnapshot{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestMappedDBReusesWrittenVintages(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := conformanceSnapshot()
+	snapshot.SourceHash = SourceHash([]byte("raw"))
+
+	_, err := MappedDBFromSnapshot(dir, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := mappedSnapshotPath(dir, snapshot.Timestamp)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected mapped snapshot to be written: %s", err)
+	}
+
+	db, err := MappedDBFromSnapshot(dir, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !before.ModTime().Equal(after.ModTime()) {
+		t.Fatal("expected existing mapped snapshot to be reused")
+	}
+
+	got, _, err := db.GetWeeklyDeaths("PL", "TOTAL", "T", 2021, 2021)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []WeekYearDeaths{{Week: 1, Year: 2021, Deaths: 3}, {Week: 2, Year: 2021, Deaths: 0}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+}
+
+func TestMappedDBRewritesVintagesWithDifferentSource(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	dir := t.TempDir()
+
+	snapshot := testingHistorySnapshot(ts, 1)
+	snapshot.SourceHash = SourceHash([]byte("first"))
+	_, err := MappedDBFromSnapshot(dir, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// different data stored under the same timestamp, also when its
+	// source is unknown
+	for i, hash := range [][32]byte{SourceHash([]byte("second")), {}} {
+		deaths := uint32(i + 2)
+		snapshot = testingHistorySnapshot(ts, deaths)
+		snapshot.SourceHash = hash
+		db, err := MappedDBFromSnapshot(dir, snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _, err := db.GetWeeklyDeaths("PL", "TOTAL", "T", 2021, 2021)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Deaths != deaths {
+			t.Fatalf("hash %x: expected mapped snapshot to be rewritten with %d deaths but got %+v", hash, deaths, got)
+		}
+	}
+}
+
+func BenchmarkFootprintMapped(b *testing.B) {
+	ds := benchmarkSnapshot()
+	dir := b.TempDir()
+
+	var db *InMemoryDB
+	for i := 0; i < b.N; i++ {
+		before := heapInUse()
+		db, _ = MappedDBFromSnapshot(dir, ds)
+		b.ReportMetric(float64(heapInUse()-before)/(1<<20), "MiB")
+	}
+	runtime.KeepAlive(db)
+}
+
+func BenchmarkQueryFullRangeMapped(b *testing.B) {
+	db, err := MappedDBFromSnapshot(b.TempDir(), benchmarkSnapshot())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _, _ = db.GetWeeklyDeaths("C10", "A05", "T", benchmarkYearFrom, benchmarkYearTo)
+	}
+}
+
+func TestMappedDBServesOlderVintages(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	source := &testingSnapshotSource{snapshots: make(map[int64]DataSnapshot)}
+	for i := 0; i < 3; i++ {
+		ds := testingHistorySnapshot(ts.AddDate(0, 0, 7*i), uint32(i))
+		source.snapshots[ds.Timestamp.UnixNano()] = ds
+	}
+
+	dir := t.TempDir()
+	db, err := MappedDBFromSnapshot(dir, testingHistorySnapshot(ts.AddDate(0, 0, 21), 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.EnableHistory(source, 3)
+
+	for i := 0; i < 3; i++ {
+		got, gotTs, err := db.GetWeeklyDeathsAsOf(ts.AddDate(0, 0, 7*i), "PL", "TOTAL", "T", 2021, 2021)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Deaths != uint32(i) {
+			t.Fatalf("vintage %d: expected %d deaths but got %+v", i, i, got)
+		}
+
+		if _, err := os.Stat(mappedSnapshotPath(dir, gotTs)); err != nil {
+			t.Fatalf("vintage %d: expected it to be mapped from file: %s", i, err)
+		}
+	}
+}
+
+func TestMappedDBRemovesUnusedFiles(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	dir := t.TempDir()
+
+	// file left by a previous run
+	stale := mappedSnapshotPath(dir, ts.AddDate(0, 0, -7))
+	err := os.WriteFile(stale, []byte("stale"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := MappedDBFromSnapshot(dir, testingHistorySnapshot(ts, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected stale mapped snapshot to be removed but got %v", err)
+	}
+
+	// snapshot read by a query stays mapped after it's replaced
+	reading := db.acquireCurrent()
+	err = db.LoadSnapshot(testingHistorySnapshot(ts.AddDate(0, 0, 7), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := mappedSnapshotPath(dir, ts)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected mapped snapshot being read to be kept: %s", err)
+	}
+	if got := reading.index.data(); len(got) != 1 || got["PL|2021|TOTAL|T"][0].Deaths != 1 {
+		t.Fatalf("expected replaced snapshot to be readable but got %+v", got)
+	}
+
+	reading.release()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected replaced mapped snapshot to be removed but got %v", err)
+	}
+	if _, err := os.Stat(mappedSnapshotPath(dir, ts.AddDate(0, 0, 7))); err != nil {
+		t.Fatalf("expected live mapped snapshot to be kept: %s", err)
+	}
+}
+
+func TestMappedDBRemovesEvictedVintages(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	source := &testingSnapshotSource{snapshots: make(map[int64]DataSnapshot)}
+	for i := 0; i < 3; i++ {
+		ds := testingHistorySnapshot(ts.AddDate(0, 0, 7*i), uint32(i))
+		source.snapshots[ds.Timestamp.UnixNano()] = ds
+	}
+
+	dir := t.TempDir()
+	db, err := MappedDBFromSnapshot(dir, testingHistorySnapshot(ts.AddDate(0, 0, 21), 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.EnableHistory(source, 1)
+
+	for i := 0; i < 3; i++ {
+		_, _, err := db.GetWeeklyDeathsAsOf(ts.AddDate(0, 0, 7*i), "PL", "TOTAL", "T", 2021, 2021)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+mappedSnapshotExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{mappedSnapshotPath(dir, ts.AddDate(0, 0, 14)), mappedSnapshotPath(dir, ts.AddDate(0, 0, 21))}
+	if !reflect.DeepEqual(want, paths) {
+		t.Fatalf("expected only live and cached snapshots to be kept mapped %v but got %v", want, paths)
+	}
+}
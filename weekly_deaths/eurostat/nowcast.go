@@ -0,0 +1,257 @@
+package eurostat
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNowcastMaxLag is the number of most recent weeks
+	// (counted from the snapshot timestamp) considered incomplete.
+	DefaultNowcastMaxLag = 8
+
+	// z-score used for the bounds of nowcast interval (~95%).
+	nowcastZScore = 1.96
+
+	// completeness factors are estimated from the total
+	// (all ages, all genders) series of each country.
+	nowcastAge    = "TOTAL"
+	nowcastGender = "T"
+
+	// lowest completeness factor used when computing upper bound
+	// of the nowcast, so that the interval stays finite.
+	minCompleteness = 0.05
+)
+
+// CompletenessFactor describes which share of eventually reported deaths
+// is typically known Lag weeks after given week.
+type CompletenessFactor struct {
+	Lag          int     `json:"lag"`
+	Factor       float64 `json:"factor"`
+	StdDev       float64 `json:"std_dev"`
+	Observations int     `json:"observations"`
+}
+
+// NowcastValue is a weekly deaths value along with its
+// reporting-delay corrected estimate and its uncertainty bounds.
+type NowcastValue struct {
+	Week    uint8   `json:"week"`
+	Year    uint16  `json:"year"`
+	Deaths  uint32  `json:"deaths"`
+	Lag     int     `json:"lag"`
+	Nowcast float64 `json:"nowcast"`
+	Lower   float64 `json:"lower"`
+	Upper   float64 `json:"upper"`
+}
+
+type nowcastObservation struct {
+	country string
+	woy     WeekOfYear
+	lag     int
+	deaths  uint32
+}
+
+// Nowcaster estimates completeness factors per country and week-lag
+// from a history of snapshots and uses them to correct the most recent,
+// under-reported weeks. Snapshots must be added in chronological order;
+// the most recent one is treated as the reference for "final" values.
+type Nowcaster struct {
+	maxLag int
+
+	mu           sync.RWMutex
+	observations []nowcastObservation
+	reference    DataSnapshot
+	factors      map[string][]CompletenessFactor
+}
+
+// NewNowcaster creates a Nowcaster treating maxLag most recent weeks
+// of each snapshot as incomplete.
+func NewNowcaster(maxLag int) *Nowcaster {
+	if maxLag <= 0 {
+		maxLag = DefaultNowcastMaxLag
+	}
+
+	return &Nowcaster{
+		maxLag:  maxLag,
+		factors: make(map[string][]CompletenessFactor),
+	}
+}
+
+// isoWeekStart returns the Monday starting given ISO week.
+func isoWeekStart(year int, week int) time.Time {
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	offset := (int(jan4.Weekday()) + 6) % 7
+	return jan4.AddDate(0, 0, -offset+(week-1)*7)
+}
+
+// weekLag returns the number of full weeks between the start
+// of given ISO week and the start of ISO week of ts.
+func weekLag(year int, week int, ts time.Time) int {
+	tsYear, tsWeek := ts.UTC().ISOWeek()
+	return int(isoWeekStart(tsYear, tsWeek).Sub(isoWeekStart(year, week)) / (7 * 24 * time.Hour))
+}
+
+// Add records reported values of the most recent weeks of the snapshot
+// and makes it the reference snapshot. Snapshots older than the current
+// reference are ignored.
+func (n *Nowcaster) Add(snapshot DataSnapshot) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !snapshot.Timestamp.After(n.reference.Timestamp) {
+		return nil
+	}
+
+	for key, values := range snapshot.Data {
+		metadata, year, err := parseKey(key)
+		if err != nil {
+			return err
+		}
+
+		if metadata.Age != nowcastAge || metadata.Gender != nowcastGender {
+			continue
+		}
+
+		for _, v := range values {
+			if v.Flags&FlagMissing != 0 {
+				continue
+			}
+
+			lag := weekLag(year, int(v.Week), snapshot.Timestamp)
+			if lag < 0 || lag > n.maxLag {
+				continue
+			}
+
+			n.observations = append(n.observations, nowcastObservation{
+				country: metadata.Country,
+				woy:     WeekOfYear{Year: year, Week: int(v.Week)},
+				lag:     lag,
+				deaths:  v.Deaths,
+			})
+		}
+	}
+
+	n.reference = snapshot
+	n.factors = n.estimate()
+	return nil
+}
+
+func (n *Nowcaster) finalValue(country string, woy WeekOfYear) (uint32, bool) {
+	key, err := makeKey(country, nowcastGender, nowcastAge, woy.Year)
+	if err != nil {
+		return 0, false
+	}
+
+	for _, v := range n.reference.Data[key] {
+		if int(v.Week) == woy.Week && v.Flags&FlagMissing == 0 {
+			return v.Deaths, true
+		}
+	}
+
+	return 0, false
+}
+
+// estimate computes completeness factors from recorded observations,
+// using only the weeks that are already considered complete
+// in the reference snapshot.
+func (n *Nowcaster) estimate() map[string][]CompletenessFactor {
+	ratios := make(map[string][][]float64)
+
+	for _, o := range n.observations {
+		if weekLag(o.woy.Year, o.woy.Week, n.reference.Timestamp) <= n.maxLag {
+			continue
+		}
+
+		final, ok := n.finalValue(o.country, o.woy)
+		if !ok || final == 0 {
+			continue
+		}
+
+		if _, ok := ratios[o.country]; !ok {
+			ratios[o.country] = make([][]float64, n.maxLag+1)
+		}
+		ratios[o.country][o.lag] = append(ratios[o.country][o.lag], float64(o.deaths)/float64(final))
+	}
+
+	factors := make(map[string][]CompletenessFactor, len(ratios))
+	for country, byLag := range ratios {
+		cf := make([]CompletenessFactor, n.maxLag+1)
+		for lag, r := range byLag {
+			cf[lag] = completenessFactor(lag, r)
+		}
+		factors[country] = cf
+	}
+
+	return factors
+}
+
+func completenessFactor(lag int, ratios []float64) CompletenessFactor {
+	cf := CompletenessFactor{Lag: lag, Factor: 1, Observations: len(ratios)}
+	if len(ratios) == 0 {
+		return cf
+	}
+
+	var sum float64
+	for _, r := range ratios {
+		sum += r
+	}
+	mean := sum / float64(len(ratios))
+
+	var variance float64
+	if len(ratios) > 1 {
+		for _, r := range ratios {
+			variance += (r - mean) * (r - mean)
+		}
+		variance /= float64(len(ratios) - 1)
+	}
+
+	if mean > 0 {
+		cf.Factor = mean
+	}
+	cf.StdDev = math.Sqrt(variance)
+	return cf
+}
+
+// Factors returns completeness factors (indexed by lag) estimated for given country.
+func (n *Nowcaster) Factors(country string) []CompletenessFactor {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.factors[country]
+}
+
+// Apply returns values enriched with nowcast estimates. Lags are computed
+// against asOf (usually timestamp of the snapshot values come from).
+// Values older than the maximum lag (or for countries without estimated
+// factors) are returned unchanged.
+func (n *Nowcaster) Apply(country string, asOf time.Time, values []WeekYearDeaths) []NowcastValue {
+	factors := n.Factors(country)
+
+	res := make([]NowcastValue, 0, len(values))
+	for _, v := range values {
+		lag := weekLag(int(v.Year), int(v.Week), asOf)
+		deaths := float64(v.Deaths)
+		nv := NowcastValue{
+			Week:    v.Week,
+			Year:    v.Year,
+			Deaths:  v.Deaths,
+			Lag:     lag,
+			Nowcast: deaths,
+			Lower:   deaths,
+			Upper:   deaths,
+		}
+
+		if lag >= 0 && lag < len(factors) && factors[lag].Observations > 0 {
+			f := factors[lag]
+			nv.Nowcast = deaths / f.Factor
+			// raw count is always a lower bound for the final value
+			nv.Lower = math.Max(deaths/(f.Factor+nowcastZScore*f.StdDev), deaths)
+			nv.Upper = deaths / math.Max(f.Factor-nowcastZScore*f.StdDev, minCompleteness)
+		}
+
+		res = append(res, nv)
+	}
+
+	return res
+}
@@ -0,0 +1,97 @@
+package eurostat
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testingNowcastSnapshot(ts time.Time, completeness []float64) DataSnapshot {
+	year, currentWeek := ts.ISOWeek()
+	values := make([]WeeklyDeaths, 0)
+	for w := 1; w <= currentWeek; w++ {
+		deaths := 100.0
+		if lag := currentWeek - w; lag < len(completeness) {
+			deaths *= completeness[lag]
+		}
+		values = append(values, WeeklyDeaths{Week: uint8(w), Deaths: uint32(deaths)})
+	}
+
+	key, _ := makeKey("PL", nowcastGender, nowcastAge, year)
+	return DataSnapshot{Data: map[string][]WeeklyDeaths{key: values}, Timestamp: ts}
+}
+
+func TestWeekLag(t *testing.T) {
+	type TestCase struct {
+		year int
+		week int
+		ts   time.Time
+		want int
+	}
+
+	cases := []TestCase{
+		{year: 2021, week: 10, ts: time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC), want: 0},
+		{year: 2021, week: 8, ts: time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC), want: 2},
+		{year: 2020, week: 53, ts: time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), want: 1},
+	}
+
+	for _, c := range cases {
+		if got := weekLag(c.year, c.week, c.ts); got != c.want {
+			t.Fatalf("weekLag(%d, %d, %s): wanted %d but got %d", c.year, c.week, c.ts, c.want, got)
+		}
+	}
+}
+
+func TestNowcasterEstimatesCompletenessFactors(t *testing.T) {
+	completeness := []float64{0.5, 0.8}
+	nowcaster := NewNowcaster(2)
+
+	start := isoWeekStart(2021, 5).Add(24 * time.Hour)
+	for i := 0; i < 10; i++ {
+		err := nowcaster.Add(testingNowcastSnapshot(start.AddDate(0, 0, 7*i), completeness))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	factors := nowcaster.Factors("PL")
+	if len(factors) != 3 {
+		t.Fatalf("expected 3 factors but got %+v", factors)
+	}
+
+	for lag, want := range []float64{0.5, 0.8, 1} {
+		if math.Abs(factors[lag].Factor-want) > 1e-9 {
+			t.Fatalf("lag %d: expected factor %f but got %+v", lag, want, factors[lag])
+		}
+		if factors[lag].Observations == 0 {
+			t.Fatalf("lag %d: expected observations but got none", lag)
+		}
+	}
+
+	asOf := start.AddDate(0, 0, 7*9)
+	year, currentWeek := asOf.ISOWeek()
+	got := nowcaster.Apply("PL", asOf, []WeekYearDeaths{
+		{Week: uint8(currentWeek - 3), Year: uint16(year), Deaths: 100},
+		{Week: uint8(currentWeek), Year: uint16(year), Deaths: 50},
+	})
+
+	if got[0].Nowcast != 100 || got[0].Lag != 3 {
+		t.Fatalf("expected value beyond max lag to be unchanged but got %+v", got[0])
+	}
+
+	if math.Abs(got[1].Nowcast-100) > 1e-9 || got[1].Lower < 50 || got[1].Upper < got[1].Nowcast {
+		t.Fatalf("expected nowcast of 100 within bounds but got %+v", got[1])
+	}
+}
+
+func TestNowcasterIgnoresOlderSnapshots(t *testing.T) {
+	nowcaster := NewNowcaster(2)
+	ts := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	_ = nowcaster.Add(testingNowcastSnapshot(ts, nil))
+	_ = nowcaster.Add(testingNowcastSnapshot(ts.AddDate(0, 0, -7), nil))
+
+	if nowcaster.reference.Timestamp != ts {
+		t.Fatalf("expected reference snapshot from %s but got %s", ts, nowcaster.reference.Timestamp)
+	}
+}
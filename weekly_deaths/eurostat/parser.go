@@ -94,11 +94,18 @@ func parseWeekOfYear(s string) (weekOfYear, error) {
 	}, nil
 }
 
-// ParseDeathsValue parses information about reported amount of deaths.
-// If no value was reported (or couldn't successfully parse the information),
-// 0 is returned.
-func parseDeathsValue(v string) (int, error) {
-	var res int
+// ParseDeathsValue parses information about reported amount of deaths
+// along with its flags. If no value was reported, 0 is returned
+// and the value is flagged as missing.
+func parseDeathsValue(v string) (int, uint8, error) {
+	var (
+		res   int
+		flags uint8
+	)
+
+	if strings.Contains(v, "p") {
+		flags |= FlagProvisional
+	}
 	v = strings.Replace(v, "p", "", -1)
 	v = strings.Replace(v, ":", "", -1)
 	v = strings.TrimSpace(v)
@@ -106,12 +113,12 @@ func parseDeathsValue(v string) (int, error) {
 	i, err := strconv.Atoi(v)
 	if err != nil {
 		if v != "" {
-			return res, fmt.Errorf("unparsable value %s: %w", v, err)
+			return res, flags, fmt.Errorf("unparsable value %s: %w", v, err)
 		}
-		return 0, nil
+		return 0, flags | FlagMissing, nil
 	}
 
-	return i, nil
+	return i, flags, nil
 }
 
 func parseMetadata(line string) (Metadata, error) {
@@ -152,9 +159,9 @@ func parseLine(line string, woyPosMap map[int]weekOfYear, results map[string][]W
 	deaths := data[1:]
 
 	for i, v := range deaths {
-		dv, err := parseDeathsValue(v)
+		dv, flags, err := parseDeathsValue(v)
 		if err != nil {
-			log.Fatalf("parsing deaths value %s: %s", v, err)
+			return fmt.Errorf("parsing deaths value %s: %w", v, err)
 		}
 		woy := woyPosMap[i+1]
 		key, err := makeKey(metadata.Country, metadata.Gender, metadata.Age, woy.Year)
@@ -170,7 +177,7 @@ func parseLine(line string, woyPosMap map[int]weekOfYear, results map[string][]W
 			continue
 		}
 
-		results[key] = append(results[key], WeeklyDeaths{Week: uint8(woy.Week), Deaths: uint32(dv)})
+		results[key] = append(results[key], WeeklyDeaths{Week: uint8(woy.Week), Deaths: uint32(dv), Flags: flags})
 	}
 
 	return nil
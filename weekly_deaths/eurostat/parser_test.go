@@ -3,8 +3,10 @@ package eurostat
 import (
 	"bytes"
 	"compress/gzip"
+	"io"
 	"log"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,14 +21,14 @@ func TestParseData(t *testing.T) {
 			key: "AD|2021|TOTAL|F",
 			value: []WeeklyDeaths{
 				{Week: 1, Deaths: 1},
-				{Week: 2, Deaths: 0},
-				{Week: 3, Deaths: 0},
+				{Week: 2, Deaths: 0, Flags: FlagMissing},
+				{Week: 3, Deaths: 0, Flags: FlagMissing},
 			},
 		},
 		{
 			key: "PL|2021|TOTAL|T",
 			value: []WeeklyDeaths{
-				{Week: 1, Deaths: 0},
+				{Week: 1, Deaths: 0, Flags: FlagMissing},
 				{Week: 2, Deaths: 123},
 				{Week: 3, Deaths: 212},
 			},
@@ -34,9 +36,9 @@ func TestParseData(t *testing.T) {
 		{
 			key: "GB|2021|TOTAL|M",
 			value: []WeeklyDeaths{
-				{Week: 1, Deaths: 0},
+				{Week: 1, Deaths: 0, Flags: FlagMissing},
 				{Week: 2, Deaths: 13},
-				{Week: 3, Deaths: 25},
+				{Week: 3, Deaths: 25, Flags: FlagProvisional},
 			},
 		},
 	}
@@ -71,3 +73,12 @@ TOTAL,M,NR,GB	25 p	13	:`))
 		}
 	}
 }
+
+func TestParseDataRejectsBadValues(t *testing.T) {
+	r := io.NopCloser(strings.NewReader("age,sex,unit,geo\\time\t2021W02\t2021W01\nTOTAL,T,NR,PL\t212\tabc"))
+
+	_, err := ParseData(r)
+	if err == nil || !strings.Contains(err.Error(), "abc") {
+		t.Fatalf("expected error for unparsable value but got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package eurostat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// pinKey is the key of the object holding the pinned snapshot.
+const pinKey = "pin.json"
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrSnapshotPinned   = errors.New("snapshot is pinned")
+	ErrSnapshotExists   = errors.New("snapshot already exists")
+)
+
+// SnapshotPin marks the snapshot that should stay live: refreshes don't
+// replace it and retention doesn't delete it.
+type SnapshotPin struct {
+	Timestamp time.Time `json:"timestamp"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
+
+// snapshotExists returns ErrSnapshotNotFound if there's no raw
+// snapshot with given timestamp.
+func (sm *SnapshotManager) snapshotExists(ts time.Time) error {
+	_, err := sm.store.Head(snapshotKey(ts, dataFileExtension))
+	if errors.Is(err, ErrSnapshotObjectNotFound) {
+		return fmt.Errorf("%w: %s", ErrSnapshotNotFound, ts.Format(timestampLayout))
+	}
+	return err
+}
+
+// Pin pins the stored snapshot with given timestamp (replacing previous pin).
+func (sm *SnapshotManager) Pin(ts time.Time) (SnapshotPin, error) {
+	pin := SnapshotPin{Timestamp: ts, PinnedAt: time.Now().UTC()}
+
+	err := sm.snapshotExists(ts)
+	if err != nil {
+		return pin, err
+	}
+
+	b, err := json.Marshal(pin)
+	if err != nil {
+		return pin, err
+	}
+	return pin, sm.store.Put(pinKey, b, nil)
+}
+
+// Unpin removes the pin (if any).
+func (sm *SnapshotManager) Unpin() error {
+	return sm.store.Delete(pinKey)
+}
+
+// Pinned returns the pinned snapshot; false if there's none.
+func (sm *SnapshotManager) Pinned() (SnapshotPin, bool, error) {
+	var pin SnapshotPin
+
+	b, err := sm.store.Get(pinKey)
+	if errors.Is(err, ErrSnapshotObjectNotFound) {
+		return pin, false, nil
+	}
+	if err != nil {
+		return pin, false, err
+	}
+
+	err = json.Unmarshal(b, &pin)
+	if err != nil {
+		return pin, false, fmt.Errorf("parsing %s: %w", pinKey, err)
+	}
+	return pin, true, nil
+}
+
+// DeleteSnapshot deletes the stored snapshot with given timestamp along
+// with its binary version and manifest. Pinned snapshot can't be deleted.
+func (sm *SnapshotManager) DeleteSnapshot(ts time.Time) error {
+	err := sm.snapshotExists(ts)
+	if err != nil {
+		return err
+	}
+
+	pin, ok, err := sm.Pinned()
+	if err != nil {
+		return err
+	}
+	if ok && pin.Timestamp.Equal(ts) {
+		return fmt.Errorf("%w: %s", ErrSnapshotPinned, ts.Format(timestampLayout))
+	}
+
+	return sm.deleteSnapshots([]string{snapshotKey(ts, dataFileExtension)})
+}
+
+// Manifests returns manifests of all stored snapshots in chronological order.
+func (sm *SnapshotManager) Manifests() ([]SnapshotManifest, error) {
+	keys, err := sm.listSnapshotsChronologically()
+	if errors.Is(err, ErrNoParsableObjectsInBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]SnapshotManifest, 0, len(keys))
+	for _, k := range keys {
+		manifest, err := sm.manifest(k)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
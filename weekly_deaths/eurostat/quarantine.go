@@ -0,0 +1,70 @@
+package eurostat
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Snapshots rejected by validation are stored under separate extensions,
+// so they're never listed nor loaded as regular snapshots.
+const (
+	quarantineExtension       = ".quarantine.gz"
+	quarantineReportExtension = ".quarantine.json"
+)
+
+// QuarantineReport describes a snapshot rejected by validation.
+type QuarantineReport struct {
+	Manifest SnapshotManifest    `json:"manifest"`
+	Failures []ValidationFailure `json:"failures"`
+}
+
+// QuarantineSnapshot stores raw data of a snapshot that failed validation
+// along with the report of failed checks.
+func (sm *SnapshotManager) QuarantineSnapshot(raw []byte, manifest SnapshotManifest, failures []ValidationFailure) error {
+	hash := SourceHash(raw)
+	err := sm.store.Put(snapshotKey(manifest.Timestamp, quarantineExtension), raw, sourceHashMetadata(hash))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(QuarantineReport{Manifest: manifest, Failures: failures}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return sm.store.Put(snapshotKey(manifest.Timestamp, quarantineReportExtension), b, nil)
+}
+
+// QuarantinedSnapshots returns reports of quarantined snapshots
+// in chronological order.
+func (sm *SnapshotManager) QuarantinedSnapshots() ([]QuarantineReport, error) {
+	keys, err := sm.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []QuarantineReport
+	for _, k := range keys {
+		if !strings.HasSuffix(k, quarantineReportExtension) {
+			continue
+		}
+
+		b, err := sm.store.Get(k)
+		if err != nil {
+			return nil, err
+		}
+
+		var report QuarantineReport
+		err = json.Unmarshal(b, &report)
+		if err != nil {
+			return nil, fmt.Errorf("parsing quarantine report %s: %w", k, err)
+		}
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Manifest.Timestamp.Before(reports[j].Manifest.Timestamp)
+	})
+	return reports, nil
+}
@@ -0,0 +1,202 @@
+package eurostat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RetentionPolicy decides which stored snapshots are kept. A snapshot is
+// kept if any of the rules applies to it; the latest snapshot is always
+// kept. Zero policy keeps everything.
+type RetentionPolicy struct {
+	// KeepLatest is the number of the latest snapshots to keep.
+	KeepLatest int
+	// KeepDays keeps all snapshots from the last KeepDays days.
+	KeepDays int
+	// KeepWeeks keeps the latest snapshot of each ISO week
+	// for the last KeepWeeks weeks.
+	KeepWeeks int
+	// KeepMonthly keeps the latest snapshot of each calendar month.
+	KeepMonthly bool
+	// DryRun only reports snapshots that would be deleted.
+	DryRun bool
+}
+
+// RetentionResult lists snapshots kept and deleted (or, in dry run,
+// to be deleted) by the retention policy.
+type RetentionResult struct {
+	Kept    []time.Time `json:"kept"`
+	Deleted []time.Time `json:"deleted"`
+	DryRun  bool        `json:"dry_run"`
+}
+
+// RetentionPolicyFromEnv reads retention policy from env variables:
+// CLEANUP_KEEP_N_LATEST_SNAPSHOTS, RETENTION_KEEP_DAYS, RETENTION_KEEP_WEEKS,
+// RETENTION_KEEP_MONTHLY and RETENTION_DRY_RUN.
+func RetentionPolicyFromEnv() (RetentionPolicy, error) {
+	var policy RetentionPolicy
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{name: "CLEANUP_KEEP_N_LATEST_SNAPSHOTS", dst: &policy.KeepLatest},
+		{name: "RETENTION_KEEP_DAYS", dst: &policy.KeepDays},
+		{name: "RETENTION_KEEP_WEEKS", dst: &policy.KeepWeeks},
+	}
+	for _, v := range ints {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("parsing %s: expected non-negative integer but got %q", v.name, s)
+		}
+		*v.dst = n
+	}
+
+	policy.KeepMonthly = os.Getenv("RETENTION_KEEP_MONTHLY") == "true"
+	policy.DryRun = os.Getenv("RETENTION_DRY_RUN") == "true"
+	return policy, nil
+}
+
+// IsZero reports whether no retention rule is set (nothing is deleted).
+func (p RetentionPolicy) IsZero() bool {
+	return p.KeepLatest == 0 && p.KeepDays == 0 && p.KeepWeeks == 0 && !p.KeepMonthly
+}
+
+// Select splits timestamps into the ones to keep and to delete at
+// the given time. Both are returned in chronological order.
+func (p RetentionPolicy) Select(timestamps []time.Time, now time.Time) (keep, remove []time.Time) {
+	sorted := append([]time.Time(nil), timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	if p.IsZero() {
+		return sorted, nil
+	}
+
+	daysFrom := now.AddDate(0, 0, -p.KeepDays)
+	weeksFrom := now.AddDate(0, 0, -7*p.KeepWeeks)
+	weeks := make(map[[2]int]bool)
+	months := make(map[[2]int]bool)
+
+	kept := make([]bool, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		ts := sorted[i].UTC()
+		nth := len(sorted) - i
+
+		year, week := ts.ISOWeek()
+		weekKey := [2]int{year, week}
+		monthKey := [2]int{ts.Year(), int(ts.Month())}
+
+		switch {
+		case nth == 1, nth <= p.KeepLatest:
+			kept[i] = true
+		case p.KeepDays > 0 && !ts.Before(daysFrom):
+			kept[i] = true
+		case p.KeepWeeks > 0 && !ts.Before(weeksFrom) && !weeks[weekKey]:
+			kept[i] = true
+		case p.KeepMonthly && !months[monthKey]:
+			kept[i] = true
+		}
+
+		// iterating from the latest, so the first snapshot
+		// seen in a week or month is the latest one
+		if !ts.Before(weeksFrom) {
+			weeks[weekKey] = true
+		}
+		months[monthKey] = true
+	}
+
+	for i, ts := range sorted {
+		if kept[i] {
+			keep = append(keep, ts)
+		} else {
+			remove = append(remove, ts)
+		}
+	}
+	return keep, remove
+}
+
+// keepPinned moves the pinned snapshot from remove to keep.
+func keepPinned(pinned time.Time, keep, remove []time.Time) ([]time.Time, []time.Time) {
+	for i, ts := range remove {
+		if !ts.Equal(pinned) {
+			continue
+		}
+
+		remove = append(remove[:i:i], remove[i+1:]...)
+		keep = append(keep, ts)
+		sort.Slice(keep, func(i, j int) bool { return keep[i].Before(keep[j]) })
+		break
+	}
+	return keep, remove
+}
+
+// ApplyRetention deletes stored snapshots (raw data along with their binary
+// versions and manifests) not kept by the policy; the pinned snapshot
+// is always kept. In dry run nothing is deleted.
+func (sm *SnapshotManager) ApplyRetention(policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	result := RetentionResult{DryRun: policy.DryRun}
+
+	timestamps, err := sm.SnapshotTimestamps()
+	if errors.Is(err, ErrNoParsableObjectsInBucket) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Kept, result.Deleted = policy.Select(timestamps, now)
+
+	pin, pinned, err := sm.Pinned()
+	if err != nil {
+		return result, err
+	}
+	if pinned {
+		result.Kept, result.Deleted = keepPinned(pin.Timestamp, result.Kept, result.Deleted)
+	}
+	if policy.DryRun || len(result.Deleted) == 0 {
+		return result, nil
+	}
+
+	keys := make([]string, 0, len(result.Deleted))
+	for _, ts := range result.Deleted {
+		keys = append(keys, snapshotKey(ts, dataFileExtension))
+	}
+	return result, sm.deleteSnapshots(keys)
+}
+
+// applyRetentionFromEnv applies the policy configured with env variables
+// (if any) and logs its outcome.
+func applyRetentionFromEnv(sm *SnapshotManager) {
+	policy, err := RetentionPolicyFromEnv()
+	if err != nil {
+		log.Printf("Skipping retention: %s\n", err)
+		return
+	}
+	if policy.IsZero() {
+		return
+	}
+
+	result, err := sm.ApplyRetention(policy, time.Now())
+	if err != nil {
+		log.Printf("Applying retention policy failed: %s\n", err)
+		return
+	}
+
+	for _, ts := range result.Deleted {
+		if policy.DryRun {
+			log.Printf("Retention (dry run): snapshot %s would be deleted.\n", ts.Format(timestampLayout))
+		} else {
+			log.Printf("Retention: snapshot %s deleted.\n", ts.Format(timestampLayout))
+		}
+	}
+	log.Printf("Retention kept %d snapshots.\n", len(result.Kept))
+}
@@ -0,0 +1,128 @@
+package eurostat
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicySelect(t *testing.T) {
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	// daily snapshots for the last 100 days
+	var timestamps []time.Time
+	for i := 99; i >= 0; i-- {
+		timestamps = append(timestamps, now.AddDate(0, 0, -i).Add(-time.Hour))
+	}
+
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2023, month, d, 11, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []time.Time
+	}{
+		{name: "zero", policy: RetentionPolicy{}, want: timestamps},
+		{name: "latest", policy: RetentionPolicy{KeepLatest: 2}, want: []time.Time{day(6, 30), day(7, 1)}},
+		{name: "days", policy: RetentionPolicy{KeepDays: 2}, want: []time.Time{day(6, 30), day(7, 1)}},
+		// weeks ending on Sundays, latest snapshot is always kept
+		{name: "weeks", policy: RetentionPolicy{KeepWeeks: 3}, want: []time.Time{day(6, 11), day(6, 18), day(6, 25), day(7, 1)}},
+		{name: "monthly", policy: RetentionPolicy{KeepMonthly: true}, want: []time.Time{day(3, 31), day(4, 30), day(5, 31), day(6, 30), day(7, 1)}},
+		{name: "combined", policy: RetentionPolicy{KeepLatest: 1, KeepDays: 1, KeepWeeks: 1, KeepMonthly: true}, want: []time.Time{day(3, 31), day(4, 30), day(5, 31), day(6, 25), day(6, 30), day(7, 1)}},
+	}
+
+	for _, c := range cases {
+		keep, remove := c.policy.Select(timestamps, now)
+		if !reflect.DeepEqual(c.want, keep) {
+			t.Fatalf("%s: expected to keep %v but got %v", c.name, c.want, keep)
+		}
+		if len(keep)+len(remove) != len(timestamps) {
+			t.Fatalf("%s: expected %d snapshots in total but got %d", c.name, len(timestamps), len(keep)+len(remove))
+		}
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	store := NewMemorySnapshotStore()
+	sm := NewSnapshotManagerWithStore(store)
+
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+	for i := 0; i < 3; i++ {
+		err := sm.PersistSnapshot(bytes.NewReader(raw), ts.AddDate(0, 0, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := RetentionPolicy{KeepLatest: 1, DryRun: true}
+	result, err := sm.ApplyRetention(policy, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Time{ts, ts.AddDate(0, 0, 1)}; !reflect.DeepEqual(want, result.Deleted) || !result.DryRun {
+		t.Fatalf("expected %v to be deleted in dry run but got %+v", want, result)
+	}
+	if keys, _ := store.List(); len(keys) != 3 {
+		t.Fatalf("expected nothing to be deleted in dry run but got %v", keys)
+	}
+
+	// pinned snapshot is kept
+	_, err = sm.Pin(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = sm.ApplyRetention(policy, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Time{ts, ts.AddDate(0, 0, 2)}; !reflect.DeepEqual(want, result.Kept) {
+		t.Fatalf("expected %v to be kept with pinned snapshot but got %+v", want, result)
+	}
+	err = sm.Unpin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy.DryRun = false
+	_, err = sm.ApplyRetention(policy, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := ts.AddDate(0, 0, 2)
+	want := []string{snapshotKey(latest, dataFileExtension)}
+	if !reflect.DeepEqual(want, keys) {
+		t.Fatalf("expected %v to be kept but got %v", want, keys)
+	}
+}
+
+func TestRetentionPolicyFromEnv(t *testing.T) {
+	t.Setenv("CLEANUP_KEEP_N_LATEST_SNAPSHOTS", "5")
+	t.Setenv("RETENTION_KEEP_DAYS", "7")
+	t.Setenv("RETENTION_KEEP_WEEKS", "8")
+	t.Setenv("RETENTION_KEEP_MONTHLY", "true")
+	t.Setenv("RETENTION_DRY_RUN", "true")
+
+	policy, err := RetentionPolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RetentionPolicy{KeepLatest: 5, KeepDays: 7, KeepWeeks: 8, KeepMonthly: true, DryRun: true}
+	if policy != want {
+		t.Fatalf("expected %+v but got %+v", want, policy)
+	}
+
+	t.Setenv("RETENTION_KEEP_DAYS", "-1")
+	_, err = RetentionPolicyFromEnv()
+	if err == nil {
+		t.Fatal("expected error for negative number of days")
+	}
+}
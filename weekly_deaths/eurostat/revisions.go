@@ -0,0 +1,156 @@
+package eurostat
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOutdatedSnapshot = errors.New("snapshot is not newer than the latest indexed one")
+	ErrTooManySnapshots = errors.New("revision index cannot hold more snapshots")
+)
+
+// Revision is a value of weekly deaths published in a snapshot.
+type Revision struct {
+	SnapshotTimestamp time.Time `json:"snapshot_timestamp"`
+	Deaths            uint32    `json:"deaths"`
+	Provisional       bool      `json:"provisional"`
+	Missing           bool      `json:"missing"`
+}
+
+// revision is a compact representation of Revision; snapshot
+// timestamps are kept once in RevisionIndex and referenced by index.
+type revision struct {
+	week     uint8
+	flags    uint8
+	snapshot uint16
+	deaths   uint32
+}
+
+// RevisionIndex keeps track of every value published across snapshots.
+// A value is recorded when it's published for the first time and each
+// time it changes (value or flags) in a subsequent snapshot, so repeated
+// publications of the same value are collapsed into the first one.
+type RevisionIndex struct {
+	mu         sync.RWMutex
+	timestamps []time.Time
+	// revisions per key created by makeKey, in chronological order
+	revisions map[string][]revision
+}
+
+// NewRevisionIndex creates an empty RevisionIndex.
+func NewRevisionIndex() *RevisionIndex {
+	return &RevisionIndex{
+		revisions: make(map[string][]revision),
+	}
+}
+
+// Backfill adds all snapshots stored by the snapshot manager to the index.
+func (idx *RevisionIndex) Backfill(sm *SnapshotManager) error {
+	return sm.ForEachSnapshot(func(ds DataSnapshot) error {
+		err := idx.Add(ds)
+		if errors.Is(err, ErrOutdatedSnapshot) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Add records values of the snapshot that differ from the latest indexed ones.
+// Snapshots must be added in chronological order.
+func (idx *RevisionIndex) Add(snapshot DataSnapshot) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if n := len(idx.timestamps); n > 0 && !snapshot.Timestamp.After(idx.timestamps[n-1]) {
+		return ErrOutdatedSnapshot
+	}
+
+	if len(idx.timestamps) > math.MaxUint16 {
+		return ErrTooManySnapshots
+	}
+
+	snapshotIdx := uint16(len(idx.timestamps))
+	idx.timestamps = append(idx.timestamps, snapshot.Timestamp)
+
+	for key, values := range snapshot.Data {
+		existing := idx.revisions[key]
+
+		var (
+			latest [maxIsoWeekNum]revision
+			seen   [maxIsoWeekNum]bool
+		)
+		for _, r := range existing {
+			if int(r.week) < len(latest) {
+				latest[r.week] = r
+				seen[r.week] = true
+			}
+		}
+
+		for _, v := range values {
+			if int(v.Week) >= len(latest) {
+				continue
+			}
+
+			if !seen[v.Week] && v.Flags&FlagMissing != 0 {
+				continue
+			}
+
+			if prev := latest[v.Week]; seen[v.Week] && prev.deaths == v.Deaths && prev.flags == v.Flags {
+				continue
+			}
+
+			existing = append(existing, revision{
+				week:     v.Week,
+				flags:    v.Flags,
+				snapshot: snapshotIdx,
+				deaths:   v.Deaths,
+			})
+		}
+
+		if len(existing) > 0 {
+			idx.revisions[key] = existing
+		}
+	}
+
+	return nil
+}
+
+// Revisions returns every published value for given series and week,
+// in chronological order.
+func (idx *RevisionIndex) Revisions(country string, age string, gender string, year int, week int) ([]Revision, error) {
+	res := make([]Revision, 0)
+
+	key, err := makeKey(country, gender, age, year)
+	if err != nil {
+		return res, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	for _, r := range idx.revisions[key] {
+		if int(r.week) != week {
+			continue
+		}
+
+		res = append(res, Revision{
+			SnapshotTimestamp: idx.timestamps[r.snapshot],
+			Deaths:            r.deaths,
+			Provisional:       r.flags&FlagProvisional != 0,
+			Missing:           r.flags&FlagMissing != 0,
+		})
+	}
+
+	return res, nil
+}
+
+// SnapshotsCount returns the number of snapshots added to the index.
+func (idx *RevisionIndex) SnapshotsCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.timestamps)
+}
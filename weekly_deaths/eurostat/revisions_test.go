@@ -0,0 +1,79 @@
+package eurostat
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRevisionIndex(t *testing.T) {
+	ts := time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC)
+	snapshots := []DataSnapshot{
+		{
+			Data: map[string][]WeeklyDeaths{
+				"PL|2021|TOTAL|T": {
+					{Week: 1, Deaths: 100, Flags: FlagProvisional},
+					{Week: 2, Deaths: 0, Flags: FlagMissing},
+				},
+			},
+			Timestamp: ts,
+		},
+		{
+			Data: map[string][]WeeklyDeaths{
+				"PL|2021|TOTAL|T": {
+					{Week: 1, Deaths: 100, Flags: FlagProvisional},
+					{Week: 2, Deaths: 50, Flags: FlagProvisional},
+				},
+			},
+			Timestamp: ts.AddDate(0, 0, 7),
+		},
+		{
+			Data: map[string][]WeeklyDeaths{
+				"PL|2021|TOTAL|T": {
+					{Week: 1, Deaths: 120},
+					{Week: 2, Deaths: 50, Flags: FlagProvisional},
+				},
+			},
+			Timestamp: ts.AddDate(0, 0, 14),
+		},
+	}
+
+	idx := NewRevisionIndex()
+	for _, s := range snapshots {
+		err := idx.Add(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := idx.Revisions("PL", "TOTAL", "T", 2021, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Revision{
+		{SnapshotTimestamp: ts, Deaths: 100, Provisional: true},
+		{SnapshotTimestamp: ts.AddDate(0, 0, 14), Deaths: 120},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+
+	got, err = idx.Revisions("PL", "TOTAL", "T", 2021, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []Revision{
+		{SnapshotTimestamp: ts.AddDate(0, 0, 7), Deaths: 50, Provisional: true},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %+v but got %+v", want, got)
+	}
+
+	err = idx.Add(snapshots[0])
+	if !errors.Is(err, ErrOutdatedSnapshot) {
+		t.Fatalf("expected ErrOutdatedSnapshot but got %v", err)
+	}
+}
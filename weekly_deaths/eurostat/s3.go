@@ -0,0 +1,249 @@
+package eurostat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3RequestTimeout limits the time of a single S3 request
+// (a page of objects in case of List).
+const s3RequestTimeout = 30 * time.Second
+
+// S3Options configures access to S3 or S3-compatible storage
+// (MinIO, Ceph, Cloudflare R2).
+type S3Options struct {
+	Bucket string
+	// Prefix is prepended to all keys, so snapshots can share the bucket
+	// with other data; "/" is appended to it if it's missing.
+	Prefix string
+	Region string
+	// Endpoint overrides the AWS endpoint (e.g. http://localhost:9000).
+	Endpoint string
+	// ForcePathStyle makes requests with bucket name in the path instead of
+	// the host name, as required by most S3-compatible services.
+	ForcePathStyle bool
+
+	// AccessKeyID and SecretAccessKey are static credentials. If they're empty,
+	// the default AWS credential chain is used (env variables, shared
+	// credentials file and profile, container and instance roles).
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3OptionsFromEnv reads S3 options from S3_BUCKET, S3_PREFIX, AWS_REGION,
+// S3_ENDPOINT and S3_FORCE_PATH_STYLE env variables. Credentials are left
+// to the default AWS credential chain.
+func S3OptionsFromEnv() S3Options {
+	return S3Options{
+		Bucket:         os.Getenv("S3_BUCKET"),
+		Prefix:         os.Getenv("S3_PREFIX"),
+		Region:         os.Getenv("AWS_REGION"),
+		Endpoint:       os.Getenv("S3_ENDPOINT"),
+		ForcePathStyle: os.Getenv("S3_FORCE_PATH_STYLE") == "true",
+	}
+}
+
+// NewS3Session creates AWS session configured with the options.
+func NewS3Session(opts S3Options) (*session.Session, error) {
+	cfg := aws.NewConfig().WithS3ForcePathStyle(opts.ForcePathStyle)
+	if opts.Region != "" {
+		cfg = cfg.WithRegion(opts.Region)
+	}
+	if opts.Endpoint != "" {
+		cfg = cfg.WithEndpoint(opts.Endpoint)
+	}
+	if opts.AccessKeyID != "" || opts.SecretAccessKey != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(opts.AccessKeyID, opts.SecretAccessKey, ""))
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+// S3SnapshotStore is a SnapshotStore keeping objects in S3 bucket.
+type S3SnapshotStore struct {
+	bucket string
+	prefix string
+	client *s3.S3
+	upload *s3manager.Uploader
+}
+
+func NewS3SnapshotStore(sess *session.Session, bucket string, prefix string) *S3SnapshotStore {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	client := s3.New(sess)
+	return &S3SnapshotStore{
+		bucket: bucket,
+		prefix: prefix,
+		client: client,
+		upload: s3manager.NewUploaderWithClient(client),
+	}
+}
+
+// NewS3SnapshotStoreWithOptions creates the store along with its session.
+func NewS3SnapshotStoreWithOptions(opts S3Options) (*S3SnapshotStore, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("S3 bucket is not configured")
+	}
+
+	sess, err := NewS3Session(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewS3SnapshotStore(sess, opts.Bucket, opts.Prefix), nil
+}
+
+// s3Error translates S3 "not found" errors to ErrSnapshotObjectNotFound.
+func s3Error(key string, err error) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch reqErr.StatusCode() {
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrSnapshotObjectNotFound, key)
+		case http.StatusPreconditionFailed, http.StatusConflict:
+			return fmt.Errorf("%w: %s", ErrPreconditionFailed, key)
+		}
+	}
+	return err
+}
+
+func (s *S3SnapshotStore) List() ([]string, error) {
+	keys := make([]string, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	err := s.client.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}, func(o *s3.ListObjectsOutput, b bool) bool {
+		for _, o := range o.Contents {
+			keys = append(keys, strings.TrimPrefix(*o.Key, s.prefix))
+		}
+		return true
+	})
+	return keys, err
+}
+
+func (s *S3SnapshotStore) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, s3Error(key, err)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+func (s *S3SnapshotStore) Head(key string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, s3Error(key, err)
+	}
+
+	metadata := make(map[string]string, len(out.Metadata))
+	for k, v := range out.Metadata {
+		if v != nil {
+			metadata[k] = *v
+		}
+	}
+	return normalizeMetadata(metadata), nil
+}
+
+func (s *S3SnapshotStore) Put(key string, data []byte, metadata map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	_, err := s.upload.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(s.prefix + key),
+		Body:     bytes.NewReader(data),
+		Metadata: aws.StringMap(metadata),
+	})
+	return err
+}
+
+func (s *S3SnapshotStore) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return err
+}
+
+func (s *S3SnapshotStore) GetWithVersion(key string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, "", s3Error(key, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	return data, aws.StringValue(out.ETag), err
+}
+
+// PutIf uses ETag of the object as its version and S3 conditional
+// writes (If-Match and If-None-Match headers).
+func (s *S3SnapshotStore) PutIf(key string, data []byte, version string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	// aws-sdk-go v1 has no fields for the conditions of PutObject
+	condition := func(r *request.Request) {
+		if version == "" {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		} else {
+			r.HTTPRequest.Header.Set("If-Match", version)
+		}
+	}
+
+	out, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   bytes.NewReader(data),
+	}, condition)
+	if err != nil {
+		return "", s3Error(key, err)
+	}
+	return aws.StringValue(out.ETag), nil
+}
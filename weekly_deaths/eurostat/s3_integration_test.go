@@ -0,0 +1,75 @@
+//go:build integration
+
+package eurostat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Integration tests run the snapshot store tests against S3-compatible
+// storage (MinIO started with docker-compose by default):
+//
+//	docker compose up -d minio
+//	go test -tags integration ./eurostat
+const integrationBucket = "weekly-deaths-integration"
+
+func integrationS3Options() S3Options {
+	opts := S3Options{
+		Bucket:          integrationBucket,
+		Region:          "us-east-1",
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		ForcePathStyle:  true,
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "http://localhost:9100"
+	}
+	if opts.AccessKeyID == "" {
+		opts.AccessKeyID, opts.SecretAccessKey = "minioadmin", "minioadmin"
+	}
+	return opts
+}
+
+func newIntegrationSnapshotStore(t *testing.T) SnapshotStore {
+	opts := integrationS3Options()
+	// every test works on its own prefix, so they don't see each other's objects
+	opts.Prefix = fmt.Sprintf("%s/%d", t.Name(), time.Now().UnixNano())
+
+	store, err := NewS3SnapshotStoreWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(opts.Bucket)})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && (awsErr.Code() == s3.ErrCodeBucketAlreadyOwnedByYou || awsErr.Code() == s3.ErrCodeBucketAlreadyExists) {
+		err = nil
+	}
+	if err != nil {
+		t.Fatalf("creating bucket at %s: %s", opts.Endpoint, err)
+	}
+
+	t.Cleanup(func() {
+		keys, err := store.List()
+		if err != nil {
+			return
+		}
+		for _, k := range keys {
+			store.Delete(k)
+		}
+	})
+	return store
+}
+
+func init() {
+	snapshotStoreBackends["minio"] = newIntegrationSnapshotStore
+}
@@ -2,98 +2,176 @@ package eurostat
 
 import (
 	"bytes"
-	"compress/gzip"
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
 	"log"
 	"os"
 	"sort"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 var (
-	ErrNoParsableObjectsInBucket = errors.New("no objects with parsable names found in S3")
+	ErrNoParsableObjectsInBucket = errors.New("no objects with parsable names found in snapshot store")
 )
 
+// sourceHashMetadataKey is the name of object metadata holding
+// SHA-256 of the raw snapshot (hex encoded). It's set on the raw
+// snapshot and on the binary snapshot created from it.
+const sourceHashMetadataKey = "source-sha256"
+
+// SnapshotManager persists snapshots in a SnapshotStore and reads them back.
 type SnapshotManager struct {
-	bucket  string
-	session *session.Session
+	store SnapshotStore
 }
 
+// NewSnapshotManager creates snapshot manager for S3 bucket, configured
+// with the remaining options read from env variables (see S3OptionsFromEnv).
 func NewSnapshotManager(bucket string) (SnapshotManager, error) {
-	var sm SnapshotManager
-
-	sess, err := session.NewSession(
-		&aws.Config{
-			Region: aws.String(os.Getenv("AWS_REGION")),
-			Credentials: credentials.NewStaticCredentials(
-				os.Getenv("AWS_ACCESS_KEY_ID"),
-				os.Getenv("AWS_SECRET_ACCESS_KEY"),
-				"",
-			),
-		})
+	opts := S3OptionsFromEnv()
+	opts.Bucket = bucket
 
+	store, err := NewS3SnapshotStoreWithOptions(opts)
 	if err != nil {
-		return sm, err
+		return SnapshotManager{}, err
 	}
-	return SnapshotManager{
-		bucket:  bucket,
-		session: sess,
-	}, nil
+	return NewSnapshotManagerWithStore(store), nil
+}
+
+// NewSnapshotManagerWithStore creates snapshot manager for any SnapshotStore.
+func NewSnapshotManagerWithStore(store SnapshotStore) SnapshotManager {
+	return SnapshotManager{store: store}
+}
+
+// NewSnapshotManagerFromEnv creates snapshot manager for the local directory
+// configured with SNAPSHOTS_DIR env variable or, if it's not set, for
+// the S3 bucket configured with S3_BUCKET.
+func NewSnapshotManagerFromEnv() (SnapshotManager, error) {
+	if dir := os.Getenv("SNAPSHOTS_DIR"); dir != "" {
+		store, err := NewDirSnapshotStore(dir)
+		if err != nil {
+			return SnapshotManager{}, err
+		}
+		return NewSnapshotManagerWithStore(store), nil
+	}
+
+	return NewSnapshotManager(os.Getenv("S3_BUCKET"))
+}
+
+func snapshotKey(timestamp time.Time, extension string) string {
+	return fmt.Sprintf("%s%s", timestamp.Format(timestampLayout), extension)
+}
+
+func binarySnapshotKey(key string) string {
+	return strings.TrimSuffix(key, dataFileExtension) + binarySnapshotExtension
+}
+
+func sourceHashMetadata(sourceHash [sha256.Size]byte) map[string]string {
+	return map[string]string{sourceHashMetadataKey: hex.EncodeToString(sourceHash[:])}
 }
 
+// PersistSnapshot stores raw snapshot data (.tsv.gz) along with its hash.
 func (sm *SnapshotManager) PersistSnapshot(r io.Reader, timestamp time.Time) error {
-	uploader := s3manager.NewUploader(sm.session)
-	_, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(sm.bucket),
-		Key:    aws.String(fmt.Sprintf("%s%s", timestamp.Format(timestampLayout), dataFileExtension)),
-		Body:   r,
-	})
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return sm.store.Put(snapshotKey(timestamp, dataFileExtension), b, sourceHashMetadata(SourceHash(b)))
 }
 
-func (sm *SnapshotManager) getSnapshot(key string) (DataSnapshot, error) {
-	var (
-		ds   DataSnapshot
-		buff aws.WriteAtBuffer
-	)
+// PersistBinarySnapshot stores the snapshot in binary format (see EncodeSnapshot),
+// so that it can be loaded without parsing the raw data.
+func (sm *SnapshotManager) PersistBinarySnapshot(snapshot DataSnapshot, sourceHash [sha256.Size]byte) error {
+	var buf bytes.Buffer
+	err := EncodeSnapshot(&buf, snapshot, sourceHash)
+	if err != nil {
+		return err
+	}
 
-	s3Client := s3.New(sm.session)
-	downloader := s3manager.NewDownloaderWithClient(s3Client)
-	_, err := downloader.Download(&buff, &s3.GetObjectInput{
-		Key:    aws.String(key),
-		Bucket: aws.String(sm.bucket),
-	})
+	return sm.store.Put(snapshotKey(snapshot.Timestamp, binarySnapshotExtension), buf.Bytes(), sourceHashMetadata(sourceHash))
+}
+
+// rawSnapshotHash returns hash of the raw snapshot stored in object metadata
+// (false if the snapshot was persisted without it).
+func (sm *SnapshotManager) rawSnapshotHash(key string) ([sha256.Size]byte, bool, error) {
+	var hash [sha256.Size]byte
 
+	metadata, err := sm.store.Head(key)
+	if err != nil {
+		return hash, false, err
+	}
+
+	b, err := hex.DecodeString(metadata[sourceHashMetadataKey])
+	if err != nil || len(b) != sha256.Size {
+		return hash, false, nil
+	}
+	copy(hash[:], b)
+	return hash, true, nil
+}
+
+// binarySnapshot loads binary version of the raw snapshot stored under key,
+// if there is one created from the same raw data.
+func (sm *SnapshotManager) binarySnapshot(key string) (DataSnapshot, error) {
+	var ds DataSnapshot
+
+	sourceHash, ok, err := sm.rawSnapshotHash(key)
 	if err != nil {
 		return ds, err
 	}
+	if !ok {
+		return ds, errors.New("raw snapshot has no hash")
+	}
+
+	b, err := sm.store.Get(binarySnapshotKey(key))
+	if err != nil {
+		return ds, err
+	}
+
+	ds, hash, err := DecodeSnapshot(bytes.NewReader(b))
+	if err != nil {
+		return ds, err
+	}
+	if hash != sourceHash {
+		return DataSnapshot{}, errors.New("binary snapshot was created from different raw data")
+	}
+
+	return ds, nil
+}
+
+// getSnapshot loads the snapshot stored under key, preferring its binary
+// version and falling back to parsing the raw data.
+func (sm *SnapshotManager) getSnapshot(key string) (DataSnapshot, error) {
+	var ds DataSnapshot
 
 	ts, err := parseTimestamp(key)
 	if err != nil {
 		return ds, err
 	}
 
-	r, err := gzip.NewReader(bytes.NewReader(buff.Bytes()))
+	ds, err = sm.binarySnapshot(key)
+	if err == nil {
+		ds.Timestamp = ts
+		return ds, nil
+	}
+	log.Printf("Binary version of %s not used: %s\n", key, err)
+
+	b, err := sm.store.Get(key)
 	if err != nil {
 		return ds, err
 	}
 
-	data, err := ParseData(r)
+	data, err := parseRawSnapshot(b)
+	if err != nil {
+		return ds, err
+	}
 	ds.Data = data
 	ds.Timestamp = ts
+	ds.SourceHash = SourceHash(b)
 
 	return ds, nil
 }
@@ -138,24 +216,17 @@ func sortSnapshotKeys(keys []string) ([]string, error) {
 }
 
 func (sm *SnapshotManager) listSnapshotsChronologically() ([]string, error) {
-	obj := make([]string, 0)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-
-	s3Client := s3.New(sm.session)
-
-	callbackFn := func(o *s3.ListObjectsOutput, b bool) bool {
-		for _, o := range o.Contents {
-			obj = append(obj, *o.Key)
-		}
-		return true
+	keys, err := sm.store.List()
+	if err != nil {
+		return keys, err
 	}
 
-	if err := s3Client.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
-		Bucket: aws.String(sm.bucket),
-		Prefix: aws.String(""),
-	}, callbackFn); err != nil {
-		return obj, err
+	obj := make([]string, 0, len(keys))
+	for _, k := range keys {
+		// binary snapshots are stored alongside raw ones
+		if strings.HasSuffix(k, dataFileExtension) {
+			obj = append(obj, k)
+		}
 	}
 
 	sorted, err := sortSnapshotKeys(obj)
@@ -169,7 +240,7 @@ func (sm *SnapshotManager) listSnapshotsChronologically() ([]string, error) {
 func (sm *SnapshotManager) LatestSnapshot() (DataSnapshot, error) {
 	var ds DataSnapshot
 
-	log.Println("Attempting to fetch latest snapshot from S3.")
+	log.Println("Attempting to fetch latest stored snapshot.")
 	obj, err := sm.listSnapshotsChronologically()
 	if err != nil {
 		return ds, err
@@ -180,10 +251,34 @@ func (sm *SnapshotManager) LatestSnapshot() (DataSnapshot, error) {
 	if err != nil {
 		return ds, err
 	}
-	log.Printf("Successfully fetched S3 snapshot (timestamp: %s)\n", ds.Timestamp)
+	log.Printf("Successfully fetched stored snapshot (timestamp: %s)\n", ds.Timestamp)
 	return ds, nil
 }
 
+// ForEachSnapshot loads all stored snapshots in chronological order
+// and passes them to fn one by one, so that only a single snapshot
+// is kept in memory at a time. Iteration stops on the first error.
+func (sm *SnapshotManager) ForEachSnapshot(fn func(DataSnapshot) error) error {
+	keys, err := sm.listSnapshotsChronologically()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		ds, err := sm.getSnapshot(k)
+		if err != nil {
+			return fmt.Errorf("loading snapshot %s: %w", k, err)
+		}
+
+		err = fn(ds)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (sm *SnapshotManager) CleanupSnapshots(keepSnapshotsNum int) {
 	keys, err := sm.listSnapshotsChronologically()
 	if err != nil {
@@ -195,31 +290,62 @@ func (sm *SnapshotManager) CleanupSnapshots(keepSnapshotsNum int) {
 		return
 	}
 
-	svc := s3.New(sm.session)
-	keysToDelete := keys[:delta]
+	err = sm.deleteSnapshots(keys[:delta])
+	if err != nil {
+		log.Printf("Cleanup operation finished with errors: %s\n", err)
+		return
+	}
+	fmt.Println("Cleanup operation finished successfully.")
+}
+
+// deleteSnapshots deletes raw snapshots with given keys
+// along with their binary versions and manifests.
+func (sm *SnapshotManager) deleteSnapshots(keys []string) error {
+	keysToDelete := make([]string, 0, 3*len(keys))
+	for _, k := range keys {
+		keysToDelete = append(keysToDelete, k, binarySnapshotKey(k), manifestKey(k))
+	}
 
-	keysLen := len(keysToDelete)
 	wg := sync.WaitGroup{}
-	wg.Add(keysLen)
-	errNumber := int64(0)
+	wg.Add(len(keysToDelete))
+	errs := make([]error, len(keysToDelete))
 
-	for _, k := range keysToDelete {
-		key := k
+	for i, k := range keysToDelete {
+		i, key := i, k
 		go func() {
-			fmt.Printf("Attempting to delete %s key...\n", key)
 			defer wg.Done()
-			_, err := svc.DeleteObject(&s3.DeleteObjectInput{
-				Bucket: aws.String(sm.bucket),
-				Key:    aws.String(key),
-			})
+			err := sm.store.Delete(key)
 			if err != nil {
-				log.Printf("Error deleting %s key: %s\n", key, err)
-				atomic.AddInt64(&errNumber, 1)
+				errs[i] = fmt.Errorf("deleting %s: %w", key, err)
 			}
-			fmt.Printf("Key %s deleted successfully!\n", key)
 		}()
 	}
 
 	wg.Wait()
-	fmt.Printf("Cleanup operation finished with %d errors.\n", errNumber)
+	return errors.Join(errs...)
+}
+
+// SnapshotTimestamps returns timestamps of all stored snapshots
+// in chronological order.
+func (sm *SnapshotManager) SnapshotTimestamps() ([]time.Time, error) {
+	keys, err := sm.listSnapshotsChronologically()
+	if err != nil {
+		return nil, err
+	}
+
+	timestamps := make([]time.Time, 0, len(keys))
+	for _, k := range keys {
+		ts, err := parseTimestamp(k)
+		if err != nil {
+			return nil, err
+		}
+		timestamps = append(timestamps, ts)
+	}
+
+	return timestamps, nil
+}
+
+// SnapshotAt returns stored snapshot with given timestamp.
+func (sm *SnapshotManager) SnapshotAt(ts time.Time) (DataSnapshot, error) {
+	return sm.getSnapshot(snapshotKey(ts, dataFileExtension))
 }
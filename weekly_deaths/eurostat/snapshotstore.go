@@ -0,0 +1,258 @@
+package eurostat
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrSnapshotObjectNotFound = errors.New("snapshot object not found")
+	ErrBadSnapshotObjectKey   = errors.New("bad snapshot object key")
+)
+
+// SnapshotStore keeps snapshot objects (raw and binary snapshots) under
+// flat keys. Metadata are small string values stored along with the object;
+// their keys are case-insensitive and always returned in lower case.
+type SnapshotStore interface {
+	// List returns keys of all stored objects in lexicographical order.
+	List() ([]string, error)
+	// Get returns content of the object, ErrSnapshotObjectNotFound if there's none.
+	Get(key string) ([]byte, error)
+	// Head returns metadata of the object without downloading it.
+	Head(key string) (map[string]string, error)
+	// Put creates or replaces the object.
+	Put(key string, data []byte, metadata map[string]string) error
+	// Delete removes the object; deleting a missing object is not an error.
+	Delete(key string) error
+}
+
+func normalizeMetadata(metadata map[string]string) map[string]string {
+	res := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		res[strings.ToLower(k)] = v
+	}
+	return res
+}
+
+// MemorySnapshotStore is a SnapshotStore keeping objects in memory.
+type MemorySnapshotStore struct {
+	mu       sync.RWMutex
+	objects  map[string][]byte
+	metadata map[string]map[string]string
+}
+
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{
+		objects:  make(map[string][]byte),
+		metadata: make(map[string]map[string]string),
+	}
+}
+
+func (s *MemorySnapshotStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemorySnapshotStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotObjectNotFound, key)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *MemorySnapshotStore) Head(key string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metadata, ok := s.metadata[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotObjectNotFound, key)
+	}
+	return normalizeMetadata(metadata), nil
+}
+
+func (s *MemorySnapshotStore) Put(key string, data []byte, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[key] = append([]byte(nil), data...)
+	s.metadata[key] = normalizeMetadata(metadata)
+	return nil
+}
+
+func (s *MemorySnapshotStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, key)
+	delete(s.metadata, key)
+	return nil
+}
+
+// DirSnapshotStore is a SnapshotStore keeping objects as files in a local
+// directory. Metadata of an object is kept in a hidden file next to it.
+type DirSnapshotStore struct {
+	dir string
+}
+
+const dirMetadataPrefix = ".meta."
+
+// NewDirSnapshotStore creates the store, creating the directory if needed.
+func NewDirSnapshotStore(dir string) (*DirSnapshotStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &DirSnapshotStore{dir: dir}, nil
+}
+
+func (s *DirSnapshotStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, ".") || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("%w: %q", ErrBadSnapshotObjectKey, key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+func (s *DirSnapshotStore) metadataPath(key string) string {
+	return filepath.Join(s.dir, dirMetadataPrefix+key)
+}
+
+func (s *DirSnapshotStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		// metadata and files being written are hidden
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		keys = append(keys, e.Name())
+	}
+	return keys, nil
+}
+
+func (s *DirSnapshotStore) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotObjectNotFound, key)
+	}
+	return data, err
+}
+
+func (s *DirSnapshotStore) Head(key string) (map[string]string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotObjectNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(s.metadataPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if ok {
+			metadata[k] = v
+		}
+	}
+	return normalizeMetadata(metadata), nil
+}
+
+func (s *DirSnapshotStore) Put(key string, data []byte, metadata map[string]string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	lines := make([]string, 0, len(metadata))
+	for k, v := range normalizeMetadata(metadata) {
+		if strings.ContainsAny(k, "=\n") || strings.Contains(v, "\n") {
+			return fmt.Errorf("bad metadata %s=%s", k, v)
+		}
+		lines = append(lines, k+"="+v)
+	}
+	sort.Strings(lines)
+
+	// metadata is written first, so it's never missing for a listed object
+	err = writeFileAtomically(s.metadataPath(key), []byte(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, data)
+}
+
+func (s *DirSnapshotStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{path, s.metadataPath(key)} {
+		err = os.Remove(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomically writes data to a hidden temporary file
+// and renames it, so a partially written file is never read.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
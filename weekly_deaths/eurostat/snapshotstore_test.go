@@ -0,0 +1,370 @@
+package eurostat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 implements the subset of S3 API used by S3SnapshotStore
+// (path-style requests to a single bucket, including conditional writes).
+type fakeS3 struct {
+	bucket string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	metadata map[string]http.Header
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:   bucket,
+		objects:  make(map[string][]byte),
+		metadata: make(map[string]http.Header),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	if key == "" && r.Method == http.MethodGet {
+		type content struct {
+			Key  string
+			Size int
+		}
+		res := struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			IsTruncated bool
+			Contents    []content
+		}{Name: f.bucket}
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				res.Contents = append(res.Contents, content{Key: k, Size: len(v)})
+			}
+		}
+		sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(res)
+		return
+	}
+
+	etag := func(b []byte) string {
+		h := md5.Sum(b)
+		return `"` + hex.EncodeToString(h[:]) + `"`
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		current, exists := f.objects[key]
+		if r.Header.Get("If-None-Match") == "*" && exists ||
+			r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != etag(current)) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusPreconditionFailed)
+			io.WriteString(w, "<Error><Code>PreconditionFailed</Code><Message>precondition failed</Message></Error>")
+			return
+		}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		metadata := make(http.Header)
+		for k, v := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				metadata[k] = v
+			}
+		}
+		f.objects[key] = b
+		f.metadata[key] = metadata
+		w.Header().Set("ETag", etag(b))
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+			}
+			return
+		}
+		for k, v := range f.metadata[key] {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", etag(b))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		delete(f.metadata, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeS3SnapshotStore(t *testing.T, prefix string) *S3SnapshotStore {
+	srv := httptest.NewServer(newFakeS3("snapshots"))
+	t.Cleanup(srv.Close)
+
+	store, err := NewS3SnapshotStoreWithOptions(S3Options{
+		Bucket:          "snapshots",
+		Prefix:          prefix,
+		Region:          "eu-central-1",
+		Endpoint:        srv.URL,
+		ForcePathStyle:  true,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+var snapshotStoreBackends = map[string]func(t *testing.T) SnapshotStore{
+	"memory": func(t *testing.T) SnapshotStore {
+		return NewMemorySnapshotStore()
+	},
+	"dir": func(t *testing.T) SnapshotStore {
+		store, err := NewDirSnapshotStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	},
+	"s3": func(t *testing.T) SnapshotStore {
+		return newFakeS3SnapshotStore(t, "")
+	},
+	"s3 with prefix": func(t *testing.T) SnapshotStore {
+		store := newFakeS3SnapshotStore(t, "weekly/deaths")
+
+		// objects outside of the prefix are not visible
+		outside := *store
+		outside.prefix = "other/"
+		err := outside.Put("20200101T000000.tsv.gz", []byte("other"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	},
+}
+
+func TestSnapshotStoreConformance(t *testing.T) {
+	for name, newStore := range snapshotStoreBackends {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			keys, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Fatalf("expected empty store but got %v", keys)
+			}
+
+			_, err = store.Get("missing.tsv.gz")
+			if !errors.Is(err, ErrSnapshotObjectNotFound) {
+				t.Fatalf("expected ErrSnapshotObjectNotFound but got %v", err)
+			}
+			_, err = store.Head("missing.tsv.gz")
+			if !errors.Is(err, ErrSnapshotObjectNotFound) {
+				t.Fatalf("expected ErrSnapshotObjectNotFound but got %v", err)
+			}
+
+			err = store.Put("b.tsv.gz", []byte("second"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.Put("a.tsv.gz", []byte("first"), map[string]string{"Source-Sha256": "abc"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err = store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a.tsv.gz", "b.tsv.gz"}; !reflect.DeepEqual(want, keys) {
+				t.Fatalf("expected keys %v but got %v", want, keys)
+			}
+
+			got, err := store.Get("a.tsv.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "first" {
+				t.Fatalf("expected %q but got %q", "first", got)
+			}
+
+			metadata, err := store.Head("a.tsv.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string]string{"source-sha256": "abc"}; !reflect.DeepEqual(want, metadata) {
+				t.Fatalf("expected metadata %v but got %v", want, metadata)
+			}
+
+			err = store.Put("a.tsv.gz", []byte("replaced"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = store.Get("a.tsv.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "replaced" {
+				t.Fatalf("expected %q but got %q", "replaced", got)
+			}
+
+			for _, k := range []string{"a.tsv.gz", "missing.tsv.gz"} {
+				err = store.Delete(k)
+				if err != nil {
+					t.Fatalf("deleting %s: %s", k, err)
+				}
+			}
+
+			keys, err = store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"b.tsv.gz"}; !reflect.DeepEqual(want, keys) {
+				t.Fatalf("expected keys %v after delete but got %v", want, keys)
+			}
+		})
+	}
+}
+
+func TestDirSnapshotStoreRejectsBadKeys(t *testing.T) {
+	store, err := NewDirSnapshotStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../a.tsv.gz", "a/b.tsv.gz", ".hidden"} {
+		err = store.Put(key, []byte("x"), nil)
+		if !errors.Is(err, ErrBadSnapshotObjectKey) {
+			t.Fatalf("key %q: expected ErrBadSnapshotObjectKey but got %v", key, err)
+		}
+	}
+}
+
+func rawSnapshot(t *testing.T, tsv string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(tsv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotManager(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for name, newStore := range snapshotStoreBackends {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			sm := NewSnapshotManagerWithStore(store)
+
+			_, err := sm.LatestSnapshot()
+			if !errors.Is(err, ErrNoParsableObjectsInBucket) {
+				t.Fatalf("expected ErrNoParsableObjectsInBucket but got %v", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t"+strings.Repeat("1", i+1))
+				err = sm.PersistSnapshot(bytes.NewReader(raw), ts.AddDate(0, 0, 7*i))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			latest, err := sm.LatestSnapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !latest.Timestamp.Equal(ts.AddDate(0, 0, 14)) || latest.Data["PL|2021|TOTAL|T"][0].Deaths != 111 {
+				t.Fatalf("expected the latest snapshot but got %+v", latest)
+			}
+
+			// binary version is preferred as long as it matches the raw data
+			raw, err := store.Get(snapshotKey(latest.Timestamp, dataFileExtension))
+			if err != nil {
+				t.Fatal(err)
+			}
+			marker := DataSnapshot{Data: map[string][]WeeklyDeaths{"PL|2021|TOTAL|T": {{Week: 1, Deaths: 999}}}, Timestamp: latest.Timestamp}
+			err = sm.PersistBinarySnapshot(marker, SourceHash(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := sm.SnapshotAt(latest.Timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(marker.Data, got.Data) {
+				t.Fatalf("expected data from binary snapshot %+v but got %+v", marker.Data, got.Data)
+			}
+
+			err = sm.PersistBinarySnapshot(marker, SourceHash([]byte("other")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = sm.SnapshotAt(latest.Timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(latest.Data, got.Data) {
+				t.Fatalf("expected data parsed from raw snapshot %+v but got %+v", latest.Data, got.Data)
+			}
+
+			timestamps, err := sm.SnapshotTimestamps()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(timestamps) != 3 {
+				t.Fatalf("expected 3 snapshots (binary ones not listed) but got %v", timestamps)
+			}
+
+			sm.CleanupSnapshots(1)
+			keys, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{snapshotKey(latest.Timestamp, binarySnapshotExtension), snapshotKey(latest.Timestamp, dataFileExtension)}
+			if !reflect.DeepEqual(want, keys) {
+				t.Fatalf("expected %v to be kept but got %v", want, keys)
+			}
+		})
+	}
+}
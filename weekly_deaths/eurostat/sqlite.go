@@ -0,0 +1,283 @@
+package eurostat
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS weekly_deaths (
+	country TEXT NOT NULL,
+	age     TEXT NOT NULL,
+	gender  TEXT NOT NULL,
+	year    INTEGER NOT NULL,
+	week    INTEGER NOT NULL,
+	deaths  INTEGER NOT NULL,
+	flags   INTEGER NOT NULL,
+	PRIMARY KEY (country, age, gender, year, week)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS snapshot_info (
+	id        INTEGER PRIMARY KEY CHECK (id = 1),
+	timestamp TEXT NOT NULL
+);
+`
+
+// SQLiteStore is a Store keeping the data in SQLite database.
+type SQLiteStore struct {
+	db *sql.DB
+
+	timestampMu sync.RWMutex
+	timestamp   time.Time
+}
+
+// NewSQLiteStore opens (creating it if needed) SQLite database
+// under given path. Use ":memory:" for a non-persistent database.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// every connection to in-memory database would see a separate database
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating sqlite schema: %w", err)
+	}
+
+	ts, err := readSnapshotTimestamp(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db, timestamp: ts}, nil
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// GetWeeklyDeaths reads the data and snapshot timestamp within
+// a single transaction, so they always come from the same snapshot.
+func (s *SQLiteStore) GetWeeklyDeaths(
+	country string,
+	age string,
+	gender string,
+	yearFrom int,
+	yearTo int,
+) ([]WeekYearDeaths, time.Time, error) {
+	var ts time.Time
+	res := make([]WeekYearDeaths, 0)
+
+	if country == "" || age == "" || gender == "" {
+		return res, s.Timestamp(), fmt.Errorf("fetching data from provider: %w", ErrEmptyDimension)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return res, ts, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT year, week, deaths FROM weekly_deaths
+		WHERE country = ? AND age = ? AND gender = ? AND year BETWEEN ? AND ?
+		ORDER BY year, week`,
+		country, age, gender, yearFrom, yearTo,
+	)
+	if err != nil {
+		return res, ts, fmt.Errorf("fetching data from sqlite: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v WeekYearDeaths
+		err = rows.Scan(&v.Year, &v.Week, &v.Deaths)
+		if err != nil {
+			return res, ts, err
+		}
+		res = append(res, v)
+	}
+	if err = rows.Err(); err != nil {
+		return res, ts, err
+	}
+
+	ts, err = readSnapshotTimestamp(tx)
+	return res, ts, err
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// readSnapshotTimestamp returns timestamp of the stored snapshot
+// (zero value if no snapshot was loaded yet).
+func readSnapshotTimestamp(q queryRower) (time.Time, error) {
+	var v string
+	err := q.QueryRow("SELECT timestamp FROM snapshot_info WHERE id = 1").Scan(&v)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return ts, fmt.Errorf("parsing stored snapshot timestamp: %w", err)
+	}
+	return ts, nil
+}
+
+func (s *SQLiteStore) Dimensions() (Dimensions, error) {
+	var dims Dimensions
+
+	columns := map[string]*[]string{
+		"country": &dims.Countries,
+		"age":     &dims.Ages,
+		"gender":  &dims.Genders,
+	}
+
+	for column, values := range columns {
+		rows, err := s.db.Query(fmt.Sprintf("SELECT DISTINCT %s FROM weekly_deaths ORDER BY %s", column, column))
+		if err != nil {
+			return dims, err
+		}
+
+		*values = make([]string, 0)
+		for rows.Next() {
+			var v string
+			err = rows.Scan(&v)
+			if err != nil {
+				rows.Close()
+				return dims, err
+			}
+			*values = append(*values, v)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return dims, err
+		}
+	}
+
+	err := s.db.QueryRow("SELECT COALESCE(MIN(year), 0), COALESCE(MAX(year), 0) FROM weekly_deaths").Scan(&dims.YearFrom, &dims.YearTo)
+	if err != nil {
+		return dims, err
+	}
+
+	return dims, nil
+}
+
+func (s *SQLiteStore) LoadSnapshot(snapshot DataSnapshot) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM weekly_deaths")
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO weekly_deaths (country, age, gender, year, week, deaths, flags) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for key, values := range snapshot.Data {
+		metadata, year, err := parseKey(key)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range values {
+			_, err = stmt.Exec(metadata.Country, metadata.Age, metadata.Gender, year, v.Week, v.Deaths, v.Flags)
+			if err != nil {
+				return fmt.Errorf("inserting %s week %d: %w", key, v.Week, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO snapshot_info (id, timestamp) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET timestamp = excluded.timestamp",
+		snapshot.Timestamp.UTC().Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	s.timestampMu.Lock()
+	s.timestamp = snapshot.Timestamp
+	s.timestampMu.Unlock()
+	return nil
+}
+
+func (s *SQLiteStore) Snapshot() (DataSnapshot, error) {
+	ds := DataSnapshot{Data: make(map[string][]WeeklyDeaths)}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return ds, err
+	}
+	defer tx.Rollback()
+
+	ds.Timestamp, err = readSnapshotTimestamp(tx)
+	if err != nil {
+		return ds, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT country, age, gender, year, week, deaths, flags FROM weekly_deaths
+		ORDER BY country, age, gender, year, week`,
+	)
+	if err != nil {
+		return ds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			metadata Metadata
+			year     int
+			v        WeeklyDeaths
+		)
+		err = rows.Scan(&metadata.Country, &metadata.Age, &metadata.Gender, &year, &v.Week, &v.Deaths, &v.Flags)
+		if err != nil {
+			return ds, err
+		}
+
+		key, err := makeKey(metadata.Country, metadata.Gender, metadata.Age, year)
+		if err != nil {
+			return ds, err
+		}
+		ds.Data[key] = append(ds.Data[key], v)
+	}
+
+	return ds, rows.Err()
+}
+
+func (s *SQLiteStore) Timestamp() time.Time {
+	s.timestampMu.RLock()
+	defer s.timestampMu.RUnlock()
+
+	return s.timestamp
+}
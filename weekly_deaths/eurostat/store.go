@@ -0,0 +1,86 @@
+package eurostat
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrHistoryNotSupported = errors.New("storage backend does not support querying older snapshots")
+	ErrEmptyDimension      = errors.New("empty series dimension")
+)
+
+// Store is a storage of weekly deaths data that the API is served from.
+type Store interface {
+	// GetWeeklyDeaths returns weekly deaths of a series for given range of years
+	// along with the timestamp of the snapshot they were read from.
+	GetWeeklyDeaths(country string, age string, gender string, yearFrom int, yearTo int) ([]WeekYearDeaths, time.Time, error)
+	// Dimensions returns values of dimensions present in the loaded data.
+	Dimensions() (Dimensions, error)
+	// LoadSnapshot replaces stored data with the snapshot.
+	LoadSnapshot(snapshot DataSnapshot) error
+	// Snapshot returns all stored data.
+	Snapshot() (DataSnapshot, error)
+	// Timestamp returns timestamp of the loaded snapshot.
+	Timestamp() time.Time
+}
+
+// HistoryStore is implemented by stores able to serve
+// data of snapshots older than the loaded one.
+type HistoryStore interface {
+	SnapshotAsOf(asOf time.Time) (DataSnapshot, error)
+	GetWeeklyDeathsAsOf(asOf time.Time, country string, age string, gender string, yearFrom int, yearTo int) ([]WeekYearDeaths, time.Time, error)
+}
+
+// Dimensions describes values of dimensions available in the data.
+type Dimensions struct {
+	Countries []string `json:"countries"`
+	Ages      []string `json:"ages"`
+	Genders   []string `json:"genders"`
+	YearFrom  int      `json:"year_from"`
+	YearTo    int      `json:"year_to"`
+}
+
+// DimensionsFromSnapshot returns (sorted) values of dimensions
+// present in the snapshot.
+func DimensionsFromSnapshot(snapshot DataSnapshot) (Dimensions, error) {
+	var (
+		countries = make(map[string]struct{})
+		ages      = make(map[string]struct{})
+		genders   = make(map[string]struct{})
+		dims      Dimensions
+	)
+
+	for key := range snapshot.Data {
+		metadata, year, err := parseKey(key)
+		if err != nil {
+			return dims, err
+		}
+
+		countries[metadata.Country] = struct{}{}
+		ages[metadata.Age] = struct{}{}
+		genders[metadata.Gender] = struct{}{}
+
+		if dims.YearFrom == 0 || year < dims.YearFrom {
+			dims.YearFrom = year
+		}
+		if year > dims.YearTo {
+			dims.YearTo = year
+		}
+	}
+
+	dims.Countries = sortedKeys(countries)
+	dims.Ages = sortedKeys(ages)
+	dims.Genders = sortedKeys(genders)
+	return dims, nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,193 @@
+package eurostat
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func conformanceSnapshot() DataSnapshot {
+	return DataSnapshot{
+		Data: map[string][]WeeklyDeaths{
+			"PL|2020|TOTAL|T": {
+				{Week: 1, Deaths: 1},
+				{Week: 2, Deaths: 2, Flags: FlagProvisional},
+			},
+			"PL|2021|TOTAL|T": {
+				{Week: 1, Deaths: 3},
+				{Week: 2, Deaths: 0, Flags: FlagMissing},
+			},
+			"DE|2019|Y_LT5|F": {
+				{Week: 52, Deaths: 7},
+			},
+		},
+		Timestamp: time.Date(2021, 1, 12, 10, 23, 11, 0, time.UTC),
+	}
+}
+
+// testStoreConformance checks behaviour expected from every Store implementation.
+func testStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("Empty", func(t *testing.T) {
+		// readiness relies on zero timestamp before any data is loaded
+		if ts := newStore(t).Timestamp(); !ts.IsZero() {
+			t.Fatalf("expected zero timestamp of empty store but got %s", ts)
+		}
+	})
+
+	t.Run("GetWeeklyDeaths", func(t *testing.T) {
+		store := newStore(t)
+		err := store.LoadSnapshot(conformanceSnapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ts, err := store.GetWeeklyDeaths("PL", "TOTAL", "T", 2020, 2021)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := conformanceSnapshot().Timestamp; !ts.Equal(want) {
+			t.Fatalf("expected timestamp %s but got %s", want, ts)
+		}
+
+		want := []WeekYearDeaths{
+			{Week: 1, Year: 2020, Deaths: 1},
+			{Week: 2, Year: 2020, Deaths: 2},
+			{Week: 1, Year: 2021, Deaths: 3},
+			{Week: 2, Year: 2021, Deaths: 0},
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected %+v but got %+v", want, got)
+		}
+
+		for _, q := range [][3]string{{"", "TOTAL", "T"}, {"PL", "", "T"}, {"PL", "TOTAL", ""}} {
+			got, _, err = store.GetWeeklyDeaths(q[0], q[1], q[2], 2020, 2021)
+			if !errors.Is(err, ErrEmptyDimension) || got == nil || len(got) != 0 {
+				t.Fatalf("series %v: expected ErrEmptyDimension and empty slice but got %+v, %v", q, got, err)
+			}
+		}
+
+		for _, q := range [][2]int{{2021, 2020}, {2010, 2011}} {
+			got, _, err = store.GetWeeklyDeaths("PL", "TOTAL", "T", q[0], q[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || len(got) != 0 {
+				t.Fatalf("years %v: expected empty slice but got %+v", q, got)
+			}
+		}
+	})
+
+	t.Run("Dimensions", func(t *testing.T) {
+		store := newStore(t)
+		err := store.LoadSnapshot(conformanceSnapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.Dimensions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := Dimensions{
+			Countries: []string{"DE", "PL"},
+			Ages:      []string{"TOTAL", "Y_LT5"},
+			Genders:   []string{"F", "T"},
+			YearFrom:  2019,
+			YearTo:    2021,
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected %+v but got %+v", want, got)
+		}
+	})
+
+	t.Run("LoadSnapshotReplacesData", func(t *testing.T) {
+		store := newStore(t)
+		err := store.LoadSnapshot(conformanceSnapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := DataSnapshot{
+			Data:      map[string][]WeeklyDeaths{"FR|2022|TOTAL|M": {{Week: 3, Deaths: 9}}},
+			Timestamp: conformanceSnapshot().Timestamp.AddDate(0, 0, 7),
+		}
+		err = store.LoadSnapshot(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ts := store.Timestamp(); !ts.Equal(next.Timestamp) {
+			t.Fatalf("expected timestamp %s but got %s", next.Timestamp, ts)
+		}
+
+		got, err := store.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(next.Data, got.Data) || !got.Timestamp.Equal(next.Timestamp) {
+			t.Fatalf("expected %+v but got %+v", next, got)
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		store := newStore(t)
+		want := conformanceSnapshot()
+		err := store.LoadSnapshot(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want.Data, got.Data) || !got.Timestamp.Equal(want.Timestamp) {
+			t.Fatalf("expected %+v but got %+v", want, got)
+		}
+	})
+}
+
+func TestInMemoryDBConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		return DBFromSnapshot(DataSnapshot{})
+	})
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "eurostat.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestSQLiteStoreKeepsTimestampAfterReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eurostat.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.LoadSnapshot(conformanceSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if want, got := conformanceSnapshot().Timestamp, store.Timestamp(); !want.Equal(got) {
+		t.Fatalf("expected timestamp %s but got %s", want, got)
+	}
+}
@@ -0,0 +1,283 @@
+package eurostat
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrSnapshotValidationFailed is returned (wrapped in ValidationError)
+// for snapshots rejected by validation rules.
+var ErrSnapshotValidationFailed = errors.New("snapshot validation failed")
+
+// Default validation rules (see ValidationRules).
+const (
+	DefaultMinSeries          = 1
+	DefaultMaxRowDropPct      = 10
+	DefaultTotalsTolerancePct = 20
+)
+
+// Names of validation checks.
+const (
+	CheckMinSeries  = "min_series"
+	CheckCountries  = "countries"
+	CheckRowCount   = "row_count"
+	CheckLatestWeek = "latest_week"
+	CheckTotals     = "totals"
+)
+
+// ValidationRules are sanity checks a new snapshot has to pass before
+// it replaces the previous one. Apart from MinSeries, the checks compare
+// the snapshot with the previous one; zero value of a rule disables it.
+type ValidationRules struct {
+	// MinSeries is the minimum number of series.
+	MinSeries int
+	// CheckCountries rejects snapshots without some of the previous countries.
+	CheckCountries bool
+	// MaxRowDropPct is the maximum drop of the number of rows in percent.
+	MaxRowDropPct float64
+	// CheckLatestWeek rejects snapshots whose latest reported
+	// week is earlier than in the previous one.
+	CheckLatestWeek bool
+	// TotalsTolerancePct is the maximum difference (in percent) of deaths
+	// per country, summed over weeks reported in both snapshots.
+	TotalsTolerancePct float64
+}
+
+// ValidationFailure describes a failed check.
+type ValidationFailure struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists checks failed by a snapshot.
+type ValidationError struct {
+	Failures []ValidationFailure
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		messages = append(messages, fmt.Sprintf("%s: %s", f.Check, f.Message))
+	}
+	return fmt.Sprintf("%s: %s", ErrSnapshotValidationFailed, strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) Unwrap() error { return ErrSnapshotValidationFailed }
+
+// SnapshotValidator checks a fetched snapshot before it's persisted and loaded.
+type SnapshotValidator func(DataSnapshot) error
+
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		MinSeries:          DefaultMinSeries,
+		CheckCountries:     true,
+		MaxRowDropPct:      DefaultMaxRowDropPct,
+		CheckLatestWeek:    true,
+		TotalsTolerancePct: DefaultTotalsTolerancePct,
+	}
+}
+
+// ValidationRulesFromEnv returns default rules overridden with env variables:
+// VALIDATION_MIN_SERIES, VALIDATION_CHECK_COUNTRIES, VALIDATION_MAX_ROW_DROP_PCT,
+// VALIDATION_CHECK_LATEST_WEEK and VALIDATION_TOTALS_TOLERANCE_PCT.
+func ValidationRulesFromEnv() (ValidationRules, error) {
+	rules := DefaultValidationRules()
+
+	if v := os.Getenv("VALIDATION_MIN_SERIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return rules, fmt.Errorf("parsing VALIDATION_MIN_SERIES: %w", err)
+		}
+		rules.MinSeries = n
+	}
+
+	floats := []struct {
+		name string
+		dst  *float64
+	}{
+		{name: "VALIDATION_MAX_ROW_DROP_PCT", dst: &rules.MaxRowDropPct},
+		{name: "VALIDATION_TOTALS_TOLERANCE_PCT", dst: &rules.TotalsTolerancePct},
+	}
+	for _, f := range floats {
+		if v := os.Getenv(f.name); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return rules, fmt.Errorf("parsing %s: %w", f.name, err)
+			}
+			*f.dst = n
+		}
+	}
+
+	if v := os.Getenv("VALIDATION_CHECK_COUNTRIES"); v != "" {
+		rules.CheckCountries = v == "true"
+	}
+	if v := os.Getenv("VALIDATION_CHECK_LATEST_WEEK"); v != "" {
+		rules.CheckLatestWeek = v == "true"
+	}
+
+	return rules, nil
+}
+
+// Against returns validator comparing snapshots with the data loaded
+// into the store (nil store or one without data - only MinSeries is checked).
+func (r ValidationRules) Against(store Store) SnapshotValidator {
+	return func(next DataSnapshot) error {
+		if store == nil || store.Timestamp().IsZero() {
+			return r.Validate(nil, next)
+		}
+
+		prev, err := store.Snapshot()
+		if err != nil {
+			return fmt.Errorf("reading live snapshot for validation: %w", err)
+		}
+		return r.Validate(&prev, next)
+	}
+}
+
+// Validate checks the next snapshot against the previous one (may be nil)
+// and returns ValidationError listing failed checks.
+func (r ValidationRules) Validate(prev *DataSnapshot, next DataSnapshot) error {
+	var failures []ValidationFailure
+	fail := func(check string, format string, args ...any) {
+		failures = append(failures, ValidationFailure{Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(next.Data) == 0 || len(next.Data) < r.MinSeries {
+		fail(CheckMinSeries, "snapshot has %d series, at least %d required", len(next.Data), r.MinSeries)
+	}
+
+	if prev != nil && len(prev.Data) > 0 {
+		if r.CheckCountries {
+			if missing := missingCountries(prev.Data, next.Data); len(missing) > 0 {
+				fail(CheckCountries, "countries missing from snapshot: %s", strings.Join(missing, ", "))
+			}
+		}
+
+		if r.MaxRowDropPct > 0 {
+			prevRows, nextRows := countRows(prev.Data), countRows(next.Data)
+			if drop := 100 * float64(prevRows-nextRows) / float64(prevRows); drop > r.MaxRowDropPct {
+				fail(CheckRowCount, "number of rows dropped from %d to %d (%.1f%%, at most %.1f%% allowed)", prevRows, nextRows, drop, r.MaxRowDropPct)
+			}
+		}
+
+		if r.CheckLatestWeek {
+			prevYear, prevWeek := latestWeek(prev.Data)
+			nextYear, nextWeek := latestWeek(next.Data)
+			if nextYear < prevYear || nextYear == prevYear && nextWeek < prevWeek {
+				fail(CheckLatestWeek, "latest reported week went back from %dW%02d to %dW%02d", prevYear, prevWeek, nextYear, nextWeek)
+			}
+		}
+
+		if r.TotalsTolerancePct > 0 {
+			for _, t := range countryTotals(prev.Data, next.Data) {
+				if t.prev == 0 {
+					continue
+				}
+				if diff := 100 * math.Abs(float64(t.next)-float64(t.prev)) / float64(t.prev); diff > r.TotalsTolerancePct {
+					fail(CheckTotals, "total deaths in %s changed from %d to %d (%.1f%%, at most %.1f%% allowed)", t.country, t.prev, t.next, diff, r.TotalsTolerancePct)
+				}
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ValidationError{Failures: failures}
+	}
+	return nil
+}
+
+func countries(data map[string][]WeeklyDeaths) map[string]struct{} {
+	res := make(map[string]struct{})
+	for key := range data {
+		metadata, _, err := parseKey(key)
+		if err == nil {
+			res[metadata.Country] = struct{}{}
+		}
+	}
+	return res
+}
+
+// missingCountries returns (sorted) countries present in prev but not in next.
+func missingCountries(prev, next map[string][]WeeklyDeaths) []string {
+	nextCountries := countries(next)
+
+	var missing []string
+	for c := range countries(prev) {
+		if _, ok := nextCountries[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// latestWeek returns the latest week with reported (not missing) value.
+func latestWeek(data map[string][]WeeklyDeaths) (year int, week int) {
+	for key, values := range data {
+		_, y, err := parseKey(key)
+		if err != nil || y < year {
+			continue
+		}
+		for _, v := range values {
+			if v.Flags&FlagMissing != 0 {
+				continue
+			}
+			if y > year || int(v.Week) > week {
+				year, week = y, int(v.Week)
+			}
+		}
+	}
+	return year, week
+}
+
+type countryTotal struct {
+	country    string
+	prev, next uint64
+}
+
+// countryTotals sums deaths per country over values reported
+// (not missing) in both snapshots.
+func countryTotals(prev, next map[string][]WeeklyDeaths) []countryTotal {
+	totals := make(map[string]*countryTotal)
+	for key, prevValues := range prev {
+		nextValues, ok := next[key]
+		if !ok {
+			continue
+		}
+		metadata, _, err := parseKey(key)
+		if err != nil {
+			continue
+		}
+
+		nextByWeek := make(map[uint8]WeeklyDeaths, len(nextValues))
+		for _, v := range nextValues {
+			nextByWeek[v.Week] = v
+		}
+
+		t, ok := totals[metadata.Country]
+		if !ok {
+			t = &countryTotal{country: metadata.Country}
+			totals[metadata.Country] = t
+		}
+		for _, p := range prevValues {
+			n, ok := nextByWeek[p.Week]
+			if !ok || p.Flags&FlagMissing != 0 || n.Flags&FlagMissing != 0 {
+				continue
+			}
+			t.prev += uint64(p.Deaths)
+			t.next += uint64(n.Deaths)
+		}
+	}
+
+	res := make([]countryTotal, 0, len(totals))
+	for _, t := range totals {
+		res = append(res, *t)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].country < res[j].country })
+	return res
+}
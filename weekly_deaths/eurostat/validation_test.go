@@ -0,0 +1,138 @@
+package eurostat
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func validationSnapshot(t *testing.T, tsv string) DataSnapshot {
+	t.Helper()
+
+	data, err := parseRawSnapshot(rawSnapshot(t, tsv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DataSnapshot{Data: data, Timestamp: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+}
+
+func TestValidationRules(t *testing.T) {
+	prev := validationSnapshot(t, `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	100	100
+TOTAL,M,NR,PL	50	50
+TOTAL,T,NR,SE	100	100`)
+
+	cases := []struct {
+		name string
+		next string
+		want []string
+	}{
+		{name: "revision and new week", next: `age,sex,unit,geo\time	2021W03	2021W02	2021W01
+TOTAL,T,NR,PL	100	105	100
+TOTAL,M,NR,PL	50	50	50
+TOTAL,T,NR,SE	100	110	100`},
+		{name: "country missing", next: `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	100	100
+TOTAL,M,NR,PL	50	50`, want: []string{CheckCountries, CheckRowCount}},
+		{name: "latest week back", next: `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	:	100
+TOTAL,M,NR,PL	:	50
+TOTAL,T,NR,SE	:	100`, want: []string{CheckLatestWeek}},
+		{name: "totals", next: `age,sex,unit,geo\time	2021W02	2021W01
+TOTAL,T,NR,PL	10	10
+TOTAL,M,NR,PL	5	5
+TOTAL,T,NR,SE	100	100`, want: []string{CheckTotals}},
+	}
+
+	rules := DefaultValidationRules()
+	for _, c := range cases {
+		err := rules.Validate(&prev, validationSnapshot(t, c.next))
+
+		var got []string
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			for _, f := range verr.Failures {
+				got = append(got, f.Check)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: unexpected error %s", c.name, err)
+		}
+
+		if !reflect.DeepEqual(c.want, got) {
+			t.Fatalf("%s: expected failed checks %v but got %v (%v)", c.name, c.want, got, err)
+		}
+		if len(c.want) > 0 && !errors.Is(err, ErrSnapshotValidationFailed) {
+			t.Fatalf("%s: expected ErrSnapshotValidationFailed but got %v", c.name, err)
+		}
+	}
+
+	err := rules.Validate(nil, DataSnapshot{})
+	if !errors.Is(err, ErrSnapshotValidationFailed) {
+		t.Fatalf("expected empty snapshot to fail validation but got %v", err)
+	}
+
+	err = ValidationRules{}.Validate(&prev, validationSnapshot(t, `age,sex,unit,geo\time	2021W01
+TOTAL,T,NR,PL	1`))
+	if err != nil {
+		t.Fatalf("expected disabled rules to pass but got %s", err)
+	}
+}
+
+func TestFetcherQuarantinesInvalidSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	// quarantined even if fetched snapshots aren't persisted
+	t.Setenv("PERSIST_LIVE_SNAPSHOTS", "")
+	t.Setenv("SNAPSHOTS_DIR", dir)
+
+	raw := rawSnapshot(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(raw)
+	}))
+	defer srv.Close()
+
+	f := NewFetcherWithOptions(FetcherOptions{URL: srv.URL})
+	f.SetValidator(ValidationRules{MinSeries: 2}.Against(nil))
+
+	_, err := f.FetchIfModified()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Failures[0].Check != CheckMinSeries {
+		t.Fatalf("expected validation error but got %v", err)
+	}
+	if status := f.Status(); status.Error == "" {
+		t.Fatalf("expected validation error to be reported but got %+v", status)
+	}
+
+	store, err := NewDirSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewSnapshotManagerWithStore(store)
+
+	_, err = sm.SnapshotTimestamps()
+	if !errors.Is(err, ErrNoParsableObjectsInBucket) {
+		t.Fatalf("expected no snapshots to be persisted but got %v", err)
+	}
+	reports, err := sm.QuarantinedSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Manifest.ETag != `"v1"` || reports[0].Failures[0].Check != CheckMinSeries {
+		t.Fatalf("expected quarantined snapshot but got %+v", reports)
+	}
+
+	// the same invalid data is not downloaded again
+	_, err = f.FetchIfModified()
+	if !errors.Is(err, ErrNotModified) || requests != 2 {
+		t.Fatalf("expected ErrNotModified but got %v", err)
+	}
+}
@@ -1,23 +1,37 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
-	"github.com/joho/godotenv"
 	"weekly_deaths/eurostat"
+	"weekly_deaths/scheduler"
 	"weekly_deaths/web"
 )
 
 // DefaultPort defines a default port that the server will be started on.
 const DefaultPort = 8080
 
+// DefaultSQLitePath defines a default path of the database used by sqlite storage backend.
+const DefaultSQLitePath = "eurostat.db"
+
+// DefaultMappedSnapshotsDir defines a default directory of files used by mapped storage backend.
+const DefaultMappedSnapshotsDir = "mapped_snapshots"
+
+// DefaultLocalSnapshotPollInterval defines how often LOCAL_SNAPSHOT_DIR is checked for new snapshot files.
+const DefaultLocalSnapshotPollInterval = 5 * time.Second
+
+var errSnapshotStoreNotConfigured = errors.New("neither SNAPSHOTS_DIR nor S3_BUCKET is configured")
+
 //go:embed frontend/dist
 var frontend embed.FS
 
@@ -31,81 +45,562 @@ func ensureAuthCredentialsLoaded(app web.Application) {
 	}
 }
 
-func initializeDataSnapshot() (eurostat.DataSnapshot, error) {
-	var (
-		snapshot eurostat.DataSnapshot
-		err      error
-	)
+// initialSnapshot is the snapshot the server starts with.
+type initialSnapshot struct {
+	eurostat.DataSnapshot
+	// path of the local file it was read from (DEPLOY_ENV=local)
+	path string
+	// refresh is true if the live snapshot should be fetched in the background
+	refresh bool
+}
+
+// initializeDataSnapshot reads the snapshot to start the server with. Fetcher
+// is made conditional on the data it was read from, when possible. In production
+// the server starts with the newest cached snapshot (possibly none) and the live
+// one is meant to be fetched from Eurostat in the background.
+func initializeDataSnapshot(fetcher *eurostat.Fetcher, sm *eurostat.SnapshotManager) (initialSnapshot, error) {
+	var initial initialSnapshot
 
 	env, ok := os.LookupEnv("DEPLOY_ENV")
 	if !ok {
-		env = "prod"
+		env = "production"
 	}
 
 	switch env {
 	case "local":
 		log.Println("DEPLOY_ENV=local; reading snapshot from disk.")
-		path := os.Getenv("LOCAL_SNAPSHOT_PATH")
-		if path == "" {
-			log.Fatal("DEPLOY_ENV set to local and LOCAL_SNAPSHOT_PATH env variable is empty. Exiting.")
+		path, err := localSnapshotPath()
+		if err != nil {
+			return initial, err
 		}
-		snapshot, err = eurostat.DataSnapshotFromPath(path)
+		initial.DataSnapshot, err = eurostat.DataSnapshotFromPath(path)
 		if err != nil {
-			return snapshot, err
+			return initial, err
 		}
+		initial.path = path
 	case "production":
-		log.Println("DEPLOY_ENV=production; reading live snapshot from Eurostat.")
-		snapshot, err = eurostat.DataSnapshotFromEurostat()
-		if err != nil && os.Getenv("USE_S3_AS_FALLBACK") == "true" {
-			log.Printf("Reading live snapshot from Eurostat failed because of: %s\n", err)
-			sm, err := eurostat.NewSnapshotManager(os.Getenv("S3_BUCKET"))
+		log.Println("DEPLOY_ENV=production; starting with cached snapshot, reading live snapshot from Eurostat in the background.")
+		initial.refresh = true
+		snapshot, err := cachedDataSnapshot(fetcher, sm)
+		if err != nil {
+			log.Printf("No cached snapshot to start with, waiting for Eurostat: %s\n", err)
+			return initial, nil
+		}
+		log.Printf("Starting with cached snapshot %s.\n", snapshot.Timestamp)
+		initial.DataSnapshot = snapshot
+	}
+
+	return initial, nil
+}
+
+// localSnapshotPath returns the newest snapshot file in LOCAL_SNAPSHOT_DIR
+// or, if it's not set, LOCAL_SNAPSHOT_PATH.
+func localSnapshotPath() (string, error) {
+	if dir := os.Getenv("LOCAL_SNAPSHOT_DIR"); dir != "" {
+		path, _, err := eurostat.LatestSnapshotPath(dir)
+		return path, err
+	}
+
+	path := os.Getenv("LOCAL_SNAPSHOT_PATH")
+	if path == "" {
+		log.Fatal("DEPLOY_ENV set to local and neither LOCAL_SNAPSHOT_DIR nor LOCAL_SNAPSHOT_PATH env variable is set. Exiting.")
+	}
+	return path, nil
+}
+
+// initializeLocalSnapshots creates LocalSnapshots reloading snapshot files
+// in DEPLOY_ENV=local (nil otherwise), and the scheduler polling LOCAL_SNAPSHOT_DIR
+// for new files every LOCAL_SNAPSHOT_POLL_INTERVAL (nil if the directory isn't set).
+func initializeLocalSnapshots(app *web.Application, loaded string) (*web.LocalSnapshots, *scheduler.Scheduler, error) {
+	if os.Getenv("DEPLOY_ENV") != "local" {
+		return nil, nil, nil
+	}
+
+	dir := os.Getenv("LOCAL_SNAPSHOT_DIR")
+	local := web.NewLocalSnapshots(app, os.Getenv("LOCAL_SNAPSHOT_PATH"), dir)
+	err := local.MarkLoaded(loaded)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dir == "" {
+		return local, nil, nil
+	}
+
+	interval := DefaultLocalSnapshotPollInterval
+	if v := os.Getenv("LOCAL_SNAPSHOT_POLL_INTERVAL"); v != "" {
+		interval, err = time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			return nil, nil, fmt.Errorf("parsing LOCAL_SNAPSHOT_POLL_INTERVAL: expected positive duration but got %q", v)
+		}
+	}
+
+	return local, scheduler.New(scheduler.Interval(interval), 0, local.Run), nil
+}
+
+// cachedDataSnapshot reads the newest snapshot from the snapshot store
+// (the pinned one, if any) or, without the store, from LOCAL_SNAPSHOT_PATH.
+func cachedDataSnapshot(fetcher *eurostat.Fetcher, sm *eurostat.SnapshotManager) (eurostat.DataSnapshot, error) {
+	if sm == nil {
+		path := os.Getenv("LOCAL_SNAPSHOT_PATH")
+		if path == "" {
+			return eurostat.DataSnapshot{}, errSnapshotStoreNotConfigured
+		}
+		return eurostat.DataSnapshotFromPath(path)
+	}
+
+	pin, ok, err := sm.Pinned()
+	if err != nil {
+		return eurostat.DataSnapshot{}, err
+	}
+	if ok {
+		return sm.SnapshotAt(pin.Timestamp)
+	}
+
+	snapshot, err := sm.LatestSnapshot()
+	if err != nil {
+		return snapshot, err
+	}
+
+	err = fetcher.LoadConditions(sm)
+	if err != nil {
+		log.Printf("Reading conditions for the next fetch failed: %s\n", err)
+	}
+	return snapshot, nil
+}
+
+// initializeHistory sets up the indexes based on the history of stored
+// snapshots (nowcast completeness factors, revision index) if they are
+// enabled and returns the backfill filling them, meant to run as a job
+// so that startup doesn't wait for the store (nil if they're disabled).
+// Stored snapshots are read in a single pass and the live snapshot is
+// added on top of them; snapshots loaded later are added as they're loaded.
+func initializeHistory(app *web.Application, sm *eurostat.SnapshotManager) (func() (web.UpdateDataResponse, error), error) {
+	var (
+		nowcaster *eurostat.Nowcaster
+		revisions *eurostat.RevisionIndex
+		consumers []func(eurostat.DataSnapshot) error
+	)
+
+	if os.Getenv("NOWCAST_ENABLED") == "true" {
+		maxLag := eurostat.DefaultNowcastMaxLag
+		if v := os.Getenv("NOWCAST_MAX_LAG_WEEKS"); v != "" {
+			n, err := strconv.Atoi(v)
 			if err != nil {
-				return snapshot, err
+				return nil, fmt.Errorf("parsing NOWCAST_MAX_LAG_WEEKS: %w", err)
 			}
+			maxLag = n
+		}
+
+		nowcaster = eurostat.NewNowcaster(maxLag)
+		consumers = append(consumers, nowcaster.Add)
+	}
+
+	if os.Getenv("REVISIONS_ENABLED") == "true" {
+		revisions = eurostat.NewRevisionIndex()
+		consumers = append(consumers, func(ds eurostat.DataSnapshot) error {
+			err := revisions.Add(ds)
+			if errors.Is(err, eurostat.ErrOutdatedSnapshot) {
+				return nil
+			}
+			return err
+		})
+	}
 
-			snapshot, err = sm.LatestSnapshot()
+	if len(consumers) == 0 {
+		return nil, nil
+	}
+
+	if sm == nil {
+		return nil, errSnapshotStoreNotConfigured
+	}
+
+	consume := func(ds eurostat.DataSnapshot) error {
+		for _, c := range consumers {
+			err := c(ds)
 			if err != nil {
-				return snapshot, err
+				return err
 			}
-		} else {
-			return snapshot, err
 		}
+		return nil
 	}
 
-	return snapshot, nil
+	app.Nowcaster = nowcaster
+	app.Revisions = revisions
+
+	return func() (web.UpdateDataResponse, error) {
+		err := sm.ForEachSnapshot(consume)
+		if err != nil {
+			return web.UpdateDataResponse{}, fmt.Errorf("reading snapshots history: %w", err)
+		}
+
+		// without cached data, the live snapshot is added once it's fetched
+		live, err := app.Db.Snapshot()
+		if err != nil {
+			return web.UpdateDataResponse{}, err
+		}
+		if !live.Timestamp.IsZero() {
+			err = consume(live)
+			if err != nil {
+				return web.UpdateDataResponse{}, err
+			}
+		}
+
+		return web.UpdateDataResponse{
+			Message:           "Snapshot history indexes built.",
+			SnapshotTimestamp: live.Timestamp,
+			NotModified:       true,
+		}, nil
+	}, nil
+}
+
+// initializeStore creates the storage backend selected with STORAGE_BACKEND
+// env var (memory - default, mapped or sqlite) and loads the snapshot into it.
+func initializeStore(snapshot eurostat.DataSnapshot) (eurostat.Store, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	switch backend {
+	case "", "memory":
+		return eurostat.DBFromSnapshot(snapshot), nil
+	case "mapped":
+		dir := os.Getenv("MAPPED_SNAPSHOTS_DIR")
+		if dir == "" {
+			dir = DefaultMappedSnapshotsDir
+		}
+		log.Printf("STORAGE_BACKEND=mapped; serving snapshots mapped from %s.\n", dir)
+
+		return eurostat.MappedDBFromSnapshot(dir, snapshot)
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = DefaultSQLitePath
+		}
+		log.Printf("STORAGE_BACKEND=sqlite; loading snapshot into %s.\n", path)
+
+		store, err := eurostat.NewSQLiteStore(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = store.LoadSnapshot(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %s", backend)
+	}
+}
+
+// enableSnapshotHistory makes stored snapshots available
+// for as_of queries.
+func enableSnapshotHistory(store eurostat.Store, sm *eurostat.SnapshotManager) error {
+	if sm == nil {
+		return errSnapshotStoreNotConfigured
+	}
+
+	db, ok := store.(*eurostat.InMemoryDB)
+	if !ok {
+		return eurostat.ErrHistoryNotSupported
+	}
+
+	cacheSize := eurostat.DefaultHistoryCacheSize
+	if v := os.Getenv("SNAPSHOT_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("parsing SNAPSHOT_CACHE_SIZE: %w", err)
+		}
+		cacheSize = n
+	}
+
+	db.EnableHistory(sm, cacheSize)
+	return nil
+}
+
+// initializeSnapshotManager creates snapshot manager for the local directory
+// or S3 bucket configured with SNAPSHOTS_DIR or S3_BUCKET env var
+// (nil if neither is configured).
+func initializeSnapshotManager() (*eurostat.SnapshotManager, error) {
+	if os.Getenv("SNAPSHOTS_DIR") == "" && os.Getenv("S3_BUCKET") == "" {
+		return nil, nil
+	}
+
+	sm, err := eurostat.NewSnapshotManagerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sm, nil
+}
+
+// initializeValidationRules reads rules new snapshots are validated with
+// before they're loaded (see eurostat.ValidationRulesFromEnv); validation
+// can be disabled with VALIDATION_ENABLED=false.
+func initializeValidationRules() (eurostat.ValidationRules, bool, error) {
+	if os.Getenv("VALIDATION_ENABLED") == "false" {
+		return eurostat.ValidationRules{}, false, nil
+	}
+
+	rules, err := eurostat.ValidationRulesFromEnv()
+	if err != nil {
+		return rules, false, err
+	}
+	return rules, true, nil
+}
+
+// initializeScheduler creates the scheduler refreshing the data according
+// to REFRESH_SCHEDULE env var (cron expression, @daily like descriptor,
+// "@every 6h" or plain duration) delayed by random REFRESH_JITTER
+// (nil if the schedule isn't configured).
+func initializeScheduler(app *web.Application) (*scheduler.Scheduler, error) {
+	spec := os.Getenv("REFRESH_SCHEDULE")
+	if spec == "" {
+		return nil, nil
+	}
+
+	schedule, err := scheduler.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing REFRESH_SCHEDULE: %w", err)
+	}
+
+	var jitter time.Duration
+	if v := os.Getenv("REFRESH_JITTER"); v != "" {
+		jitter, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing REFRESH_JITTER: %w", err)
+		}
+	}
+
+	return scheduler.New(schedule, jitter, app.ScheduledRefresh), nil
+}
+
+// initializeSnapshotPickup creates the scheduler polling the snapshot store
+// for newer snapshots every SNAPSHOT_POLL_INTERVAL (nil if it's not configured).
+func initializeSnapshotPickup(app *web.Application) (*scheduler.Scheduler, error) {
+	v := os.Getenv("SNAPSHOT_POLL_INTERVAL")
+	if v == "" {
+		return nil, nil
+	}
+
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("parsing SNAPSHOT_POLL_INTERVAL: expected positive duration but got %q", v)
+	}
+	if app.SnapshotManager == nil {
+		return nil, fmt.Errorf("SNAPSHOT_POLL_INTERVAL is set: %w", errSnapshotStoreNotConfigured)
+	}
+
+	return scheduler.New(scheduler.Interval(interval), 0, web.NewSnapshotPickup(app).Run), nil
+}
+
+// initializeLeaderElection acquires the lease electing the instance that runs
+// scheduled refreshes and retention, if LEADER_ELECTION_ENABLED is true.
+// It returns the scheduler renewing the lease every third of LEADER_LEASE_TTL.
+func initializeLeaderElection(app *web.Application) (*scheduler.Scheduler, error) {
+	if os.Getenv("LEADER_ELECTION_ENABLED") != "true" {
+		return nil, nil
+	}
+	if app.SnapshotManager == nil {
+		return nil, fmt.Errorf("LEADER_ELECTION_ENABLED is set: %w", errSnapshotStoreNotConfigured)
+	}
+
+	ttl := eurostat.DefaultLeaseTTL
+	if v := os.Getenv("LEADER_LEASE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("parsing LEADER_LEASE_TTL: expected positive duration but got %q", v)
+		}
+		ttl = d
+	}
+
+	instance := os.Getenv("INSTANCE_ID")
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("reading hostname for INSTANCE_ID: %w", err)
+		}
+		instance = hostname
+	}
+
+	lease, err := app.SnapshotManager.NewLease(instance, ttl)
+	if err != nil {
+		return nil, err
+	}
+	app.Lease = lease
+	app.Fetcher.SetLease(lease)
+
+	renew := func(ctx context.Context) (string, error) {
+		leader, err := lease.TryAcquire()
+		if err != nil {
+			return "", err
+		}
+		if leader {
+			return "leader", nil
+		}
+		return fmt.Sprintf("follower, lease held by %q", lease.Status().Holder), nil
+	}
+	_, err = renew(context.Background())
+	if err != nil {
+		log.Printf("Acquiring leader lease failed: %s\n", err)
+	}
+
+	return scheduler.New(scheduler.Interval(ttl/3), 0, renew), nil
 }
 
 func main() {
 	var port int
 
+	// loaded before subcommands, which read the snapshot store and
+	// retention policy configuration from it as well
+	env := newDotEnv("./.env")
+	envErr := env.load()
+	if envErr != nil && !errors.Is(envErr, fs.ErrNotExist) {
+		log.Fatal(envErr)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		err := runDiffCommand(os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "retention" {
+		err := runRetentionCommand(os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	flag.IntVar(&port, "port", DefaultPort, "port to start server on")
 	flag.Parse()
 
 	startTime := time.Now()
-	err := godotenv.Load("./.env")
-	if err != nil {
+	if errors.Is(envErr, fs.ErrNotExist) {
 		log.Println(".env file not found.")
 	}
 
-	snapshot, err := initializeDataSnapshot()
+	fetcherOpts, err := eurostat.FetcherOptionsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fetcher := eurostat.NewFetcherWithOptions(fetcherOpts)
+
+	validation := &validation{}
+	err = validation.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fetcher.SetValidator(validation.against(nil))
+
+	sm, err := initializeSnapshotManager()
+	if err != nil {
+		log.Printf("Snapshot manager not available: %s\n", err)
+	}
+
+	initial, err := initializeDataSnapshot(fetcher, sm)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	db := eurostat.DBFromSnapshot(snapshot)
+	db, err := initializeStore(initial.DataSnapshot)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if os.Getenv("AS_OF_QUERIES_ENABLED") == "true" {
+		err = enableSnapshotHistory(db, sm)
+		if err != nil {
+			log.Printf("as_of queries disabled: %s\n", err)
+		}
+	}
+
 	app := web.Application{
-		Db: db,
+		Db:       db,
+		Coverage: eurostat.NewCoverageCache(),
+		Fetcher:  fetcher,
+		Jobs:     web.NewJobs(),
+	}
+	app.Validator = validation.against(db)
+	fetcher.SetValidator(app.Validator)
+	if sm != nil {
+		app.SnapshotManager = sm
+	}
+
+	backfill, err := initializeHistory(&app, sm)
+	if err != nil {
+		log.Printf("Snapshot history features disabled: %s\n", err)
+	}
+
+	election, err := initializeLeaderElection(&app)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if election != nil {
+		status := app.Lease.Status()
+		log.Printf("Leader election enabled for instance %s (leader: %t).\n", status.Instance, status.Leader)
+		if os.Getenv("SNAPSHOT_POLL_INTERVAL") == "" {
+			log.Println("SNAPSHOT_POLL_INTERVAL is not set, followers won't pick up snapshots fetched by the leader.")
+		}
+		election.Start(context.Background())
+	}
+
+	sched, err := initializeScheduler(&app)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sched != nil {
+		log.Printf("Refreshing data on schedule %s.\n", sched.Status().Schedule)
+		app.Scheduler = sched
+		sched.Start(context.Background())
+	}
+
+	pickup, err := initializeSnapshotPickup(&app)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pickup != nil {
+		log.Printf("Polling snapshot store for new snapshots %s.\n", pickup.Status().Schedule)
+		app.PickupSchedule = pickup
+		pickup.Start(context.Background())
+	}
+
+	local, watch, err := initializeLocalSnapshots(&app, initial.path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if watch != nil {
+		log.Printf("Watching %s for new snapshot files %s.\n", os.Getenv("LOCAL_SNAPSHOT_DIR"), watch.Status().Schedule)
+		app.LocalWatch = watch
+		watch.Start(context.Background())
 	}
+
+	reloader := &reloader{env: env, validation: validation, local: local}
+	reloader.reloadOnSignal(context.Background())
+
 	app.Auth.Username = os.Getenv("AUTH_USERNAME")
 	app.Auth.Password = os.Getenv("AUTH_PASSWORD")
 	ensureAuthCredentialsLoaded(app)
 
 	router := app.Routes()
 
+	// jobs run in order, so the history is read before snapshots are loaded
+	if backfill != nil {
+		job, _ := app.Jobs.Submit(web.JobTriggerStartup, "snapshot history", backfill)
+		log.Printf("Reading snapshot history in the background (job %s).\n", job.ID)
+	}
+	if sm != nil {
+		job := app.SubmitPinnedSnapshot()
+		log.Printf("Loading pinned snapshot (if any) in the background (job %s).\n", job.ID)
+	}
+	if initial.refresh && app.Lease != nil && !app.Lease.IsLeader() {
+		// only the leader fetches from Eurostat; followers pick up its snapshot
+		log.Println("Not the leader, waiting for the live snapshot to be picked up from the snapshot store.")
+	} else if initial.refresh {
+		job, _ := app.SubmitRefresh(web.JobTriggerStartup)
+		log.Printf("Fetching live snapshot from Eurostat in the background (job %s).\n", job.ID)
+	}
+
 	stripped, err := fs.Sub(frontend, "frontend/dist")
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"weekly_deaths/eurostat"
+	"weekly_deaths/web"
+)
+
+// dotEnv loads variables from .env file without overriding the ones set in
+// the process environment. When it's reloaded, variables removed from the
+// file are unset.
+type dotEnv struct {
+	path    string
+	process map[string]bool
+	loaded  map[string]bool
+}
+
+func newDotEnv(path string) *dotEnv {
+	process := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		process[k] = true
+	}
+	return &dotEnv{path: path, process: process, loaded: make(map[string]bool)}
+}
+
+func (e *dotEnv) load() error {
+	vars, err := godotenv.Read(e.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	for k := range e.loaded {
+		if _, ok := vars[k]; !ok {
+			os.Unsetenv(k)
+		}
+	}
+
+	e.loaded = make(map[string]bool)
+	for k, v := range vars {
+		if e.process[k] {
+			continue
+		}
+		err = os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+		e.loaded[k] = true
+	}
+
+	if vars == nil {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// validation holds rules snapshots are validated with, replaced when
+// the configuration is reloaded (nil if validation is disabled).
+type validation struct {
+	rules atomic.Pointer[eurostat.ValidationRules]
+}
+
+func (v *validation) load() error {
+	rules, enabled, err := initializeValidationRules()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		v.rules.Store(nil)
+		return nil
+	}
+	v.rules.Store(&rules)
+	return nil
+}
+
+// against returns validator checking snapshots against the live one in store
+// (see eurostat.ValidationRules.Against) with the current rules.
+func (v *validation) against(store eurostat.Store) eurostat.SnapshotValidator {
+	return func(ds eurostat.DataSnapshot) error {
+		rules := v.rules.Load()
+		if rules == nil {
+			return nil
+		}
+		return rules.Against(store)(ds)
+	}
+}
+
+// reloader reloads the configuration and local snapshots without restarting
+// the server. Variables read on use (e.g. retention policy) take effect once
+// .env file is reread; validation rules and the local snapshot source
+// are reloaded explicitly.
+type reloader struct {
+	env        *dotEnv
+	validation *validation
+	local      *web.LocalSnapshots
+}
+
+func (r *reloader) reload(ctx context.Context) {
+	err := r.env.load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Reloading .env file failed: %s\n", err)
+	}
+
+	err = r.validation.load()
+	if err != nil {
+		log.Printf("Reloading validation rules failed, keeping the previous ones: %s\n", err)
+	}
+
+	if r.local != nil {
+		r.local.SetSource(os.Getenv("LOCAL_SNAPSHOT_PATH"), os.Getenv("LOCAL_SNAPSHOT_DIR"))
+		msg, err := r.local.Reload(ctx)
+		if err != nil {
+			log.Printf("Reloading local snapshot failed: %s\n", err)
+		} else {
+			log.Println(msg)
+		}
+	}
+}
+
+// reloadOnSignal reloads on every SIGHUP.
+func (r *reloader) reloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				log.Println("SIGHUP received; reloading configuration and snapshots.")
+				r.reload(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
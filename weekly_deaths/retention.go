@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"time"
+
+	"weekly_deaths/eurostat"
+)
+
+// runRetentionCommand applies the retention policy configured with env
+// variables to the snapshot store and writes kept and deleted snapshots
+// as JSON. Usage: retention [-dry-run].
+func runRetentionCommand(args []string, w io.Writer) error {
+	var dryRun bool
+
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry-run", false, "only list snapshots that would be deleted")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	policy, err := eurostat.RetentionPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	if policy.IsZero() {
+		return errors.New("retention policy is not configured")
+	}
+	policy.DryRun = policy.DryRun || dryRun
+
+	sm, err := initializeSnapshotManager()
+	if err != nil {
+		return err
+	}
+	if sm == nil {
+		return errSnapshotStoreNotConfigured
+	}
+
+	result, err := sm.ApplyRetention(policy, time.Now())
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	from := time.Date(2023, 7, 1, 10, 17, 30, 0, time.UTC) // Saturday
+
+	cases := []struct {
+		spec string
+		want []time.Time
+	}{
+		{spec: "6h", want: []time.Time{from.Add(6 * time.Hour), from.Add(12 * time.Hour)}},
+		{spec: "@every 30m", want: []time.Time{from.Add(30 * time.Minute), from.Add(time.Hour)}},
+		{spec: "*/15 * * * *", want: []time.Time{
+			time.Date(2023, 7, 1, 10, 30, 0, 0, time.UTC),
+			time.Date(2023, 7, 1, 10, 45, 0, 0, time.UTC),
+		}},
+		{spec: "@daily", want: []time.Time{
+			time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 7, 3, 0, 0, 0, 0, time.UTC),
+		}},
+		// 11:00 on weekdays
+		{spec: "0 11 * * 1-5", want: []time.Time{
+			time.Date(2023, 7, 3, 11, 0, 0, 0, time.UTC),
+			time.Date(2023, 7, 4, 11, 0, 0, 0, time.UTC),
+		}},
+		// day of month or Sunday (7)
+		{spec: "0 0 15 * 7", want: []time.Time{
+			time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 7, 9, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC),
+		}},
+		{spec: "5,10 3 1 1,6 *", want: []time.Time{
+			time.Date(2024, 1, 1, 3, 5, 0, 0, time.UTC),
+			time.Date(2024, 1, 1, 3, 10, 0, 0, time.UTC),
+			time.Date(2024, 6, 1, 3, 5, 0, 0, time.UTC),
+		}},
+		{spec: "0 0 30 2 *", want: []time.Time{{}}},
+	}
+
+	for _, c := range cases {
+		schedule, err := Parse(c.spec)
+		if err != nil {
+			t.Fatalf("%s: Expected error to be nil but got %s\n", c.spec, err)
+		}
+
+		prev := from
+		for _, want := range c.want {
+			got := schedule.Next(prev)
+			if !got.Equal(want) {
+				t.Fatalf("%s: expected next run after %s at %s but got %s", c.spec, prev, want, got)
+			}
+			prev = got
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every -1h", "@every soon"} {
+		_, err := Parse(spec)
+		if err == nil {
+			t.Fatalf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestSchedulerRunsJob(t *testing.T) {
+	runs := make(chan struct{}, 10)
+	calls := 0
+	s := New(Interval(10*time.Millisecond), 0, func(ctx context.Context) (string, error) {
+		calls++
+		defer func() { runs <- struct{}{} }()
+		if calls == 2 {
+			return "", errors.New("upstream down")
+		}
+		return "loaded", nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatal("expected job to be run")
+		}
+	}
+	cancel()
+
+	// the last run is recorded after the job returns
+	time.Sleep(10 * time.Millisecond)
+	status := s.Status()
+	if len(status.History) < 3 || status.LastRun == nil {
+		t.Fatalf("expected 3 runs in history but got %+v", status)
+	}
+
+	runsByAge := status.History[len(status.History)-3:]
+	if runsByAge[2].Message != "loaded" || runsByAge[1].Error != "upstream down" || runsByAge[0].Message != "loaded" {
+		t.Fatalf("unexpected history %+v", status.History)
+	}
+	if status.Schedule != "@every 10ms" {
+		t.Fatalf("unexpected schedule %s", status.Schedule)
+	}
+}
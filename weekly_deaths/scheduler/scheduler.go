@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// historySize is the number of recent runs kept by Scheduler.
+const historySize = 20
+
+// Job is a function run by Scheduler; the returned message
+// describes the outcome of a successful run.
+type Job func(ctx context.Context) (string, error)
+
+// Run describes a single run of a job.
+type Run struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Status describes the schedule and recent runs, most recent first.
+type Status struct {
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+	LastRun  *Run      `json:"last_run,omitempty"`
+	History  []Run     `json:"history"`
+}
+
+// Scheduler runs a job according to the schedule. Every run is delayed
+// by a random duration up to jitter, so that many instances with the same
+// schedule don't hit the upstream at the same time. Runs never overlap.
+type Scheduler struct {
+	schedule Schedule
+	jitter   time.Duration
+	job      Job
+
+	mu      sync.Mutex
+	nextRun time.Time
+	history []Run
+}
+
+func New(schedule Schedule, jitter time.Duration, job Job) *Scheduler {
+	return &Scheduler{
+		schedule: schedule,
+		jitter:   jitter,
+		job:      job,
+	}
+}
+
+// next returns the time of the next run after given time, including jitter.
+func (s *Scheduler) next(after time.Time) time.Time {
+	t := s.schedule.Next(after)
+	if t.IsZero() || s.jitter <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+}
+
+// Start runs the job in background until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	go s.loop(ctx)
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		next := s.next(time.Now())
+
+		s.mu.Lock()
+		s.nextRun = next
+		s.mu.Unlock()
+
+		if next.IsZero() {
+			log.Printf("Schedule %s has no more runs.\n", s.schedule)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runJob(ctx)
+	}
+}
+
+func (s *Scheduler) runJob(ctx context.Context) {
+	run := Run{StartedAt: time.Now().UTC()}
+	msg, err := s.job(ctx)
+	run.FinishedAt = time.Now().UTC()
+	run.Message = msg
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Scheduled run failed: %s\n", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history = append([]Run{run}, s.history...)
+	if len(s.history) > historySize {
+		s.history = s.history[:historySize]
+	}
+}
+
+// Status returns the schedule, time of the next run and recent runs.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Schedule: s.schedule.String(),
+		NextRun:  s.nextRun,
+		History:  append(make([]Run, 0, len(s.history)), s.history...),
+	}
+	if len(s.history) > 0 {
+		last := s.history[0]
+		status.LastRun = &last
+	}
+	return status
+}
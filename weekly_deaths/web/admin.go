@@ -0,0 +1,325 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"weekly_deaths/eurostat"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// snapshotManager returns the snapshot manager used by admin endpoints,
+// writing an error response if the snapshot store is not configured.
+func (app *Application) snapshotManager(w http.ResponseWriter) (*eurostat.SnapshotManager, bool) {
+	if app.SnapshotManager == nil {
+		_ = writeJSONError(http.StatusServiceUnavailable, w, "snapshot store is not configured")
+		return nil, false
+	}
+	return app.SnapshotManager, true
+}
+
+// snapshotTimestamp parses timestamp URL param, writing
+// an error response if it's invalid.
+func snapshotTimestamp(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	ts, err := parseTimestampParam(chi.URLParam(r, "timestamp"))
+	if err != nil {
+		_ = writeJSONError(http.StatusBadRequest, w, []map[string]string{{"field": "timestamp", errorMessageKey: failedConversionToTimestampMessage}})
+		return ts, false
+	}
+	return ts, true
+}
+
+// writeAdminError writes response for errors of snapshot manager operations.
+func writeAdminError(w http.ResponseWriter, err error) {
+	var verr *eurostat.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		_ = writeJSONError(http.StatusUnprocessableEntity, w, verr.Failures)
+	case errors.Is(err, eurostat.ErrSnapshotNotFound), errors.Is(err, eurostat.ErrSnapshotObjectNotFound):
+		_ = writeJSONError(http.StatusNotFound, w, err.Error())
+	case errors.Is(err, eurostat.ErrSnapshotPinned), errors.Is(err, eurostat.ErrSnapshotExists):
+		_ = writeJSONError(http.StatusConflict, w, err.Error())
+	default:
+		log.Printf("Snapshot operation failed: %s\n", err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+	}
+}
+
+// runJob runs fn in a data update job and waits for its outcome. The error
+// returned by fn is passed as is, unless the job was coalesced into another one.
+func (app *Application) runJob(ctx context.Context, trigger, source string, fn func() (UpdateDataResponse, error)) (UpdateDataResponse, error) {
+	var (
+		res   UpdateDataResponse
+		fnErr error
+	)
+	job, coalesced := app.Jobs.Submit(trigger, source, func() (UpdateDataResponse, error) {
+		res, fnErr = fn()
+		return res, fnErr
+	})
+
+	job, err := app.Jobs.Wait(ctx, job.ID)
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+	if !coalesced {
+		return res, fnErr
+	}
+	if job.State == JobFailed {
+		return UpdateDataResponse{}, errors.New(job.Error)
+	}
+	return *job.Result, nil
+}
+
+// loadStoredSnapshot makes the stored snapshot live in a job, so that it
+// doesn't race with refreshes; it's validated first unless force is set.
+func (app *Application) loadStoredSnapshot(ctx context.Context, sm *eurostat.SnapshotManager, ts time.Time, force bool) (UpdateDataResponse, error) {
+	source := fmt.Sprintf("%s %s", pickupSource, ts.Format(time.RFC3339))
+	return app.runJob(ctx, JobTriggerAdmin, source, func() (UpdateDataResponse, error) {
+		return app.loadStored(sm, ts, force)
+	})
+}
+
+// SubmitPinnedSnapshot queues a job making the pinned snapshot (if any)
+// live, e.g. on start. Like pinning, it's loaded without validation.
+func (app *Application) SubmitPinnedSnapshot() Job {
+	sm := app.SnapshotManager
+	job, _ := app.Jobs.Submit(JobTriggerStartup, pickupSource+" pin", func() (UpdateDataResponse, error) {
+		pin, pinned, err := sm.Pinned()
+		if err != nil {
+			return UpdateDataResponse{}, err
+		}
+
+		live := app.Db.Timestamp()
+		if !pinned || live.Equal(pin.Timestamp) {
+			return UpdateDataResponse{
+				Message:           "Pinned snapshot is live already or no snapshot is pinned.",
+				SnapshotTimestamp: live,
+				NotModified:       true,
+				Pinned:            pinned,
+			}, nil
+		}
+
+		log.Printf("Snapshot %s is pinned; loading it.\n", pin.Timestamp)
+		return app.loadStored(sm, pin.Timestamp, true)
+	})
+	return job
+}
+
+// loadStored makes the stored snapshot live; it must run in a job.
+func (app *Application) loadStored(sm *eurostat.SnapshotManager, ts time.Time, force bool) (UpdateDataResponse, error) {
+	snapshot, err := sm.SnapshotAt(ts)
+	if errors.Is(err, eurostat.ErrSnapshotObjectNotFound) {
+		return UpdateDataResponse{}, fmt.Errorf("%w: %s", eurostat.ErrSnapshotNotFound, ts.Format(time.RFC3339))
+	}
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+
+	if !force {
+		err = app.validate(snapshot)
+		if err != nil {
+			return UpdateDataResponse{}, err
+		}
+	}
+
+	err = app.loadSnapshot(snapshot)
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+
+	// snapshots stored so far are not picked up after a rollback
+	if app.pickup != nil {
+		timestamps, err := sm.SnapshotTimestamps()
+		if err != nil {
+			return UpdateDataResponse{}, err
+		}
+		app.pickup.markSeen(timestamps[len(timestamps)-1])
+	}
+
+	return UpdateDataResponse{
+		Message:           fmt.Sprintf("Successfully loaded snapshot for %s.", ts),
+		SnapshotTimestamp: ts,
+	}, nil
+}
+
+// SnapshotsHandler is an HTTP handler listing stored snapshots
+// with their manifests, along with the live and pinned snapshot
+// and reports of quarantined ones.
+func (app *Application) SnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+
+	manifests, err := sm.Manifests()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	pin, pinned, err := sm.Pinned()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	quarantined, err := sm.QuarantinedSnapshots()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	res := SnapshotsResponse{
+		LiveTimestamp: app.Db.Timestamp(),
+		Snapshots:     make([]StoredSnapshot, 0, len(manifests)),
+		Quarantined:   make([]eurostat.QuarantineReport, 0, len(quarantined)),
+	}
+	if pinned {
+		res.Pinned = &pin
+	}
+	for _, m := range manifests {
+		res.Snapshots = append(res.Snapshots, StoredSnapshot{
+			SnapshotManifest: m,
+			Live:             m.Timestamp.Equal(res.LiveTimestamp),
+			Pinned:           pinned && m.Timestamp.Equal(pin.Timestamp),
+		})
+	}
+	res.Quarantined = append(res.Quarantined, quarantined...)
+
+	_ = writeJSON(http.StatusOK, w, res)
+}
+
+// LoadSnapshotHandler is an HTTP handler loading stored snapshot
+// into the live database (e.g. to roll back a bad update). The snapshot
+// is validated against the live one unless force=true. Snapshot pickup
+// doesn't replace it with snapshots that were already stored.
+func (app *Application) LoadSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+	ts, ok := snapshotTimestamp(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := app.loadStoredSnapshot(r.Context(), sm, ts, r.URL.Query().Get("force") == "true")
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	log.Printf("Loaded stored snapshot %s.\n", ts)
+	_ = writeJSON(http.StatusOK, w, res)
+}
+
+// PinSnapshotHandler is an HTTP handler pinning stored snapshot, so that
+// refreshes don't replace it. The snapshot is loaded if it's not live.
+func (app *Application) PinSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+	ts, ok := snapshotTimestamp(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := sm.Pin(ts)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	if !app.Db.Timestamp().Equal(ts) {
+		// pinning is an explicit choice of the snapshot, it's not validated
+		_, err = app.loadStoredSnapshot(r.Context(), sm, ts, true)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+	}
+
+	log.Printf("Pinned snapshot %s.\n", ts)
+	_ = writeJSON(http.StatusOK, w, UpdateDataResponse{
+		Message:           fmt.Sprintf("Snapshot for %s is pinned.", ts),
+		SnapshotTimestamp: ts,
+		Pinned:            true,
+	})
+}
+
+// UnpinSnapshotHandler is an HTTP handler removing the pin,
+// so that refreshes load new data again.
+func (app *Application) UnpinSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+
+	err := sm.Unpin()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	log.Println("Snapshot unpinned.")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteSnapshotHandler is an HTTP handler deleting stored snapshot.
+func (app *Application) DeleteSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+	ts, ok := snapshotTimestamp(w, r)
+	if !ok {
+		return
+	}
+
+	err := sm.DeleteSnapshot(ts)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	log.Printf("Deleted snapshot %s.\n", ts)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RetentionHandler is an HTTP handler applying the retention policy
+// configured with env variables; with dry_run=true it only lists
+// snapshots that would be deleted.
+func (app *Application) RetentionHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+
+	policy, err := eurostat.RetentionPolicyFromEnv()
+	if err != nil {
+		log.Printf("Reading retention policy failed: %s\n", err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
+	}
+	if policy.IsZero() {
+		_ = writeJSONError(http.StatusConflict, w, "retention policy is not configured")
+		return
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		policy.DryRun = true
+	}
+
+	result, err := sm.ApplyRetention(policy, time.Now())
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	_ = writeJSON(http.StatusOK, w, result)
+}
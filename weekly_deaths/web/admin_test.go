@@ -0,0 +1,305 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"weekly_deaths/eurostat"
+)
+
+func gzipTSV(t *testing.T, tsv string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(tsv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotAdminHandlers(t *testing.T) {
+	sm := eurostat.NewSnapshotManagerWithStore(eurostat.NewMemorySnapshotStore())
+
+	first := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := first.AddDate(0, 0, 7)
+	var latest eurostat.DataSnapshot
+	for i, ts := range []time.Time{first, second} {
+		tsv := fmt.Sprintf("age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t%d", i+1)
+		raw := gzipTSV(t, tsv)
+		data, err := eurostat.ParseData(io.NopCloser(strings.NewReader(tsv)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest = eurostat.DataSnapshot{Data: data, Timestamp: ts}
+		_, err = sm.StoreSnapshot(raw, latest, eurostat.NewSnapshotManifest(raw, latest, "", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rejected := eurostat.DataSnapshot{Timestamp: second.AddDate(0, 0, 7)}
+	failures := []eurostat.ValidationFailure{{Check: eurostat.CheckMinSeries, Message: "too few series"}}
+	err := sm.QuarantineSnapshot(nil, eurostat.NewSnapshotManifest(nil, rejected, "", nil), failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{
+		Db:              eurostat.DBFromSnapshot(latest),
+		SnapshotManager: &sm,
+	}
+	app.Auth.Username = testUsername
+	app.Auth.Password = testPassword
+	router := app.Routes()
+
+	serve := func(method, url string, wantCode int, v any) {
+		t.Helper()
+
+		req := httptest.NewRequest(method, url, nil)
+		req.SetBasicAuth(testUsername, testPassword)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != wantCode {
+			t.Fatalf("%s %s: expected status %d but got %d: %s", method, url, wantCode, rr.Code, rr.Body.String())
+		}
+
+		if v != nil {
+			err := json.NewDecoder(rr.Body).Decode(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var list SnapshotsResponse
+	serve("GET", "/api/admin/snapshots", http.StatusOK, &list)
+	if len(list.Snapshots) != 2 || list.Pinned != nil || !list.Snapshots[1].Live || list.Snapshots[0].Live || list.Snapshots[0].Rows != 1 {
+		t.Fatalf("unexpected snapshots %+v", list)
+	}
+	if len(list.Quarantined) != 1 || list.Quarantined[0].Failures[0].Check != eurostat.CheckMinSeries {
+		t.Fatalf("expected quarantined snapshot to be reported but got %+v", list.Quarantined)
+	}
+
+	// rollback is validated unless forced
+	pickup := NewSnapshotPickup(&app)
+	app.Validator = eurostat.ValidationRules{MinSeries: 2}.Against(app.Db)
+	serve("POST", "/api/admin/snapshots/20230102T030405/load", http.StatusUnprocessableEntity, nil)
+	serve("POST", "/api/admin/snapshots/20230102T030405/load?force=true", http.StatusOK, nil)
+	if !app.Db.Timestamp().Equal(first) {
+		t.Fatalf("expected snapshot %s to be live but got %s", first, app.Db.Timestamp())
+	}
+	app.Validator = nil
+
+	// pickup doesn't undo the rollback
+	_, err = pickup.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !app.Db.Timestamp().Equal(first) {
+		t.Fatalf("expected rolled back snapshot %s to stay live but got %s", first, app.Db.Timestamp())
+	}
+	serve("POST", "/api/admin/snapshots/20200101T000000/load", http.StatusNotFound, nil)
+	serve("POST", "/api/admin/snapshots/yesterday/load", http.StatusBadRequest, nil)
+
+	// pinned snapshot is loaded, kept by refreshes and can't be deleted
+	serve("POST", "/api/admin/snapshots/20230109T030405/pin", http.StatusOK, nil)
+	if !app.Db.Timestamp().Equal(second) {
+		t.Fatalf("expected pinned snapshot %s to be live but got %s", second, app.Db.Timestamp())
+	}
+	res, err := app.RefreshData()
+	if err != nil || !res.Pinned {
+		t.Fatalf("expected refresh to be skipped but got %+v, %v", res, err)
+	}
+	serve("DELETE", "/api/admin/snapshots/20230109T030405", http.StatusConflict, nil)
+
+	serve("GET", "/api/admin/snapshots", http.StatusOK, &list)
+	if list.Pinned == nil || !list.Pinned.Timestamp.Equal(second) || !list.Snapshots[1].Pinned {
+		t.Fatalf("expected pinned snapshot but got %+v", list)
+	}
+
+	t.Setenv("CLEANUP_KEEP_N_LATEST_SNAPSHOTS", "")
+	serve("POST", "/api/admin/retention", http.StatusConflict, nil)
+
+	t.Setenv("CLEANUP_KEEP_N_LATEST_SNAPSHOTS", "1")
+	var retention eurostat.RetentionResult
+	serve("POST", "/api/admin/retention?dry_run=true", http.StatusOK, &retention)
+	if !retention.DryRun || len(retention.Deleted) != 1 || !retention.Deleted[0].Equal(first) {
+		t.Fatalf("unexpected retention result %+v", retention)
+	}
+
+	serve("DELETE", "/api/admin/snapshots/20230102T030405", http.StatusNoContent, nil)
+	serve("DELETE", "/api/admin/snapshots/20230102T030405", http.StatusNotFound, nil)
+	serve("DELETE", "/api/admin/pin", http.StatusNoContent, nil)
+
+	list = SnapshotsResponse{}
+	serve("GET", "/api/admin/snapshots", http.StatusOK, &list)
+	if len(list.Snapshots) != 1 || list.Pinned != nil {
+		t.Fatalf("expected single unpinned snapshot but got %+v", list)
+	}
+}
+
+func TestUploadSnapshotHandler(t *testing.T) {
+	sm := eurostat.NewSnapshotManagerWithStore(eurostat.NewMemorySnapshotStore())
+	app := Application{
+		Db:              testingDB(),
+		SnapshotManager: &sm,
+	}
+	app.Auth.Username = testUsername
+	app.Auth.Password = testPassword
+	router := app.Routes()
+
+	upload := func(filename string, content []byte, fields map[string]string, wantCode int) UploadSnapshotResponse {
+		t.Helper()
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		fw, err := mw.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/api/admin/snapshots", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.SetBasicAuth(testUsername, testPassword)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != wantCode {
+			t.Fatalf("uploading %s: expected status %d but got %d: %s", filename, wantCode, rr.Code, rr.Body.String())
+		}
+
+		var res UploadSnapshotResponse
+		if wantCode == http.StatusCreated {
+			err = json.NewDecoder(rr.Body).Decode(&res)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res
+	}
+
+	tsv := "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t77"
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	upload("data.csv", []byte(tsv), nil, http.StatusBadRequest)
+	upload("bad.tsv", []byte("age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\tabc"), nil, http.StatusBadRequest)
+	upload("data.tsv.gz", []byte(tsv), nil, http.StatusBadRequest)
+	upload("empty.tsv", []byte("age,sex,unit,geo\\time\t2021W01\n"), nil, http.StatusUnprocessableEntity)
+
+	res := upload("data.tsv.gz", gzipTSV(t, tsv), map[string]string{"timestamp": "20230102T030405"}, http.StatusCreated)
+	if !res.Stored || res.Loaded || !res.Manifest.Timestamp.Equal(ts) || res.Manifest.SourceURL != "upload:data.tsv.gz" {
+		t.Fatalf("expected stored snapshot but got %+v", res)
+	}
+	if !app.Db.Timestamp().Equal(testTimestamp()) {
+		t.Fatal("expected uploaded snapshot not to be loaded")
+	}
+
+	stored, err := sm.SnapshotAt(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data["PL|2021|TOTAL|T"][0].Deaths != 77 {
+		t.Fatalf("unexpected stored data %+v", stored.Data)
+	}
+
+	// stored snapshots (the pinned one included) are never replaced
+	other := "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t78"
+	upload("data.tsv", []byte(other), map[string]string{"timestamp": "20230102T030405"}, http.StatusConflict)
+	_, err = sm.Pin(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload("data.tsv", []byte(other), map[string]string{"timestamp": "20230102T030405"}, http.StatusConflict)
+	err = sm.Unpin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err = sm.SnapshotAt(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data["PL|2021|TOTAL|T"][0].Deaths != 77 {
+		t.Fatalf("expected stored data not to be replaced but got %+v", stored.Data)
+	}
+
+	// validation failures are reported unless forced
+	app.Validator = eurostat.ValidationRules{MinSeries: 2}.Against(app.Db)
+	upload("data.tsv", []byte(tsv), map[string]string{"load": "true"}, http.StatusUnprocessableEntity)
+
+	res = upload("data.tsv", []byte(tsv+"\nTOTAL,F,NR,PL\t1"), map[string]string{"load": "true", "force": "true"}, http.StatusCreated)
+	if !res.Stored || !res.Loaded || res.Manifest.Rows != 2 {
+		t.Fatalf("expected stored and loaded snapshot but got %+v", res)
+	}
+	got, _, _ := app.Db.GetWeeklyDeaths("PL", "TOTAL", "T", 2021, 2021)
+	if len(got) != 1 || got[0].Deaths != 77 {
+		t.Fatalf("expected uploaded data to be live but got %+v", got)
+	}
+}
+
+func TestSubmitPinnedSnapshot(t *testing.T) {
+	sm := eurostat.NewSnapshotManagerWithStore(eurostat.NewMemorySnapshotStore())
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tsv := "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t77"
+	data, err := eurostat.ParseData(io.NopCloser(strings.NewReader(tsv)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := gzipTSV(t, tsv)
+	snapshot := eurostat.DataSnapshot{Data: data, Timestamp: ts}
+	_, err = sm.StoreSnapshot(raw, snapshot, eurostat.NewSnapshotManifest(raw, snapshot, "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{
+		Db:              testingDB(),
+		SnapshotManager: &sm,
+		Revisions:       eurostat.NewRevisionIndex(),
+		Jobs:            NewJobs(),
+	}
+
+	// nothing to load without a pin
+	job, err := app.Jobs.Wait(context.Background(), app.SubmitPinnedSnapshot().ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobSucceeded || !job.Result.NotModified || !app.Db.Timestamp().Equal(testTimestamp()) {
+		t.Fatalf("expected live snapshot to be kept but got %+v", job)
+	}
+
+	_, err = sm.Pin(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err = app.Jobs.Wait(context.Background(), app.SubmitPinnedSnapshot().ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobSucceeded || !app.Db.Timestamp().Equal(ts) {
+		t.Fatalf("expected pinned snapshot %s to be loaded but got %+v", ts, job)
+	}
+	revisions, err := app.Revisions.Revisions("PL", "TOTAL", "T", 2021, 1)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("expected pinned snapshot to be added to revision index but got %+v, %v", revisions, err)
+	}
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned when the job doesn't exist
+// or was removed from the history.
+var ErrJobNotFound = errors.New("job not found")
+
+// jobsHistorySize is the number of finished jobs kept by Jobs.
+const jobsHistorySize = 100
+
+// JobState describes the progress of a job.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Triggers of data update jobs.
+const (
+	JobTriggerManual    = "manual"
+	JobTriggerScheduled = "scheduled"
+	JobTriggerPickup    = "pickup"
+	JobTriggerStartup   = "startup"
+	JobTriggerWatch     = "watch"
+	JobTriggerReload    = "reload"
+	JobTriggerAdmin     = "admin"
+)
+
+// Job describes a data update job.
+type Job struct {
+	ID         string              `json:"id"`
+	Trigger    string              `json:"trigger"`
+	State      JobState            `json:"state"`
+	Source     string              `json:"source,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	StartedAt  *time.Time          `json:"started_at,omitempty"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+	Result     *UpdateDataResponse `json:"result,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// Finished reports whether the job succeeded or failed.
+func (j Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed
+}
+
+// Jobs runs data update jobs in background, one at a time in the order
+// they were submitted. Jobs of the same kind (see jobKey) submitted while
+// another one is queued or running are coalesced into it. Recently finished
+// jobs are kept for status checks.
+type Jobs struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	done    map[string]chan struct{}
+	order   []string
+	active  map[string]*Job
+	queue   []queuedJob
+	running bool
+}
+
+type queuedJob struct {
+	job *Job
+	key string
+	fn  func() (UpdateDataResponse, error)
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{
+		jobs:   make(map[string]*Job),
+		done:   make(map[string]chan struct{}),
+		active: make(map[string]*Job),
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// jobKey identifies jobs doing the same work: manual, scheduled and startup
+// refreshes all fetch from Eurostat, other triggers are distinct kinds.
+func jobKey(trigger, source string) string {
+	switch trigger {
+	case JobTriggerManual, JobTriggerScheduled, JobTriggerStartup:
+		trigger = "refresh"
+	}
+	return trigger + "|" + source
+}
+
+// Submit queues a job running fn unless a job of the same kind is already
+// queued or running; in that case it's returned instead and coalesced is true.
+func (js *Jobs) Submit(trigger, source string, fn func() (UpdateDataResponse, error)) (job Job, coalesced bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	key := jobKey(trigger, source)
+	if j, ok := js.active[key]; ok {
+		return *j, true
+	}
+
+	j := &Job{
+		ID:        newJobID(),
+		Trigger:   trigger,
+		State:     JobQueued,
+		Source:    source,
+		CreatedAt: time.Now().UTC(),
+	}
+	js.jobs[j.ID] = j
+	js.done[j.ID] = make(chan struct{})
+	js.order = append(js.order, j.ID)
+	js.active[key] = j
+	js.queue = append(js.queue, queuedJob{job: j, key: key, fn: fn})
+	js.evict()
+
+	if !js.running {
+		js.running = true
+		go js.work()
+	}
+	return *j, false
+}
+
+// evict removes the oldest finished jobs exceeding jobsHistorySize.
+func (js *Jobs) evict() {
+	for len(js.order) > jobsHistorySize {
+		id := js.order[0]
+		if !js.jobs[id].Finished() {
+			return
+		}
+		js.order = js.order[1:]
+		delete(js.jobs, id)
+		delete(js.done, id)
+	}
+}
+
+// work runs queued jobs until the queue is empty.
+func (js *Jobs) work() {
+	for {
+		js.mu.Lock()
+		if len(js.queue) == 0 {
+			js.running = false
+			js.mu.Unlock()
+			return
+		}
+		q := js.queue[0]
+		js.queue = js.queue[1:]
+		js.mu.Unlock()
+
+		js.run(q)
+	}
+}
+
+func (js *Jobs) run(q queuedJob) {
+	js.mu.Lock()
+	j := q.job
+	started := time.Now().UTC()
+	j.StartedAt = &started
+	j.State = JobRunning
+	done := js.done[j.ID]
+	js.mu.Unlock()
+
+	res, err := q.fn()
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	finished := time.Now().UTC()
+	j.FinishedAt = &finished
+	if err != nil {
+		j.State = JobFailed
+		j.Error = err.Error()
+	} else {
+		j.State = JobSucceeded
+		j.Result = &res
+	}
+	delete(js.active, q.key)
+	close(done)
+}
+
+// Get returns the job with given ID.
+func (js *Jobs) Get(id string) (Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	j, ok := js.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// Wait waits until the job with given ID is finished and returns it.
+func (js *Jobs) Wait(ctx context.Context, id string) (Job, error) {
+	js.mu.Lock()
+	done, ok := js.done[id]
+	js.mu.Unlock()
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+
+	job, ok := js.Get(id)
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
@@ -0,0 +1,55 @@
+package web
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestJobsCoalesceOnlySameKind(t *testing.T) {
+	js := NewJobs()
+	release := make(chan struct{})
+	var ran []string
+
+	fn := func(name string) func() (UpdateDataResponse, error) {
+		return func() (UpdateDataResponse, error) {
+			if name == "pickup" {
+				<-release
+			}
+			ran = append(ran, name)
+			return UpdateDataResponse{Message: name}, nil
+		}
+	}
+
+	pickup, _ := js.Submit(JobTriggerPickup, pickupSource, fn("pickup"))
+	manual, coalesced := js.Submit(JobTriggerManual, "eurostat", fn("refresh"))
+	if coalesced || manual.ID == pickup.ID {
+		t.Fatalf("expected refresh not to be coalesced into running pickup but got %+v", manual)
+	}
+	scheduled, coalesced := js.Submit(JobTriggerScheduled, "eurostat", fn("scheduled"))
+	if !coalesced || scheduled.ID != manual.ID {
+		t.Fatalf("expected scheduled refresh to be coalesced into queued one but got %+v", scheduled)
+	}
+	watch, _ := js.Submit(JobTriggerWatch, "dir", fn("watch"))
+	reload, coalesced := js.Submit(JobTriggerReload, "dir", fn("reload"))
+	if coalesced || reload.ID == watch.ID {
+		t.Fatalf("expected reload not to be coalesced into queued watch but got %+v", reload)
+	}
+
+	close(release)
+	for _, id := range []string{pickup.ID, manual.ID, watch.ID, reload.ID} {
+		job, err := js.Wait(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != JobSucceeded {
+			t.Fatalf("expected job %s to succeed but got %+v", id, job)
+		}
+	}
+
+	// jobs run one at a time in the order they were submitted
+	want := []string{"pickup", "refresh", "watch", "reload"}
+	if !reflect.DeepEqual(want, ran) {
+		t.Fatalf("expected jobs to run in order %v but got %v", want, ran)
+	}
+}
@@ -0,0 +1,178 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"weekly_deaths/eurostat"
+)
+
+// localFile identifies a version of a local snapshot file.
+type localFile struct {
+	path    string
+	modTime int64
+	size    int64
+}
+
+// LocalSnapshots loads local snapshot files: the newest one in a directory
+// (by the timestamp in its name) or a single file. Run loads the file once
+// it changes; Reload loads it unconditionally. A file failing to load is
+// skipped until it changes again (e.g. once it's fully copied).
+type LocalSnapshots struct {
+	app *Application
+
+	mu       sync.Mutex
+	path     string
+	dir      string
+	loaded   localFile
+	rejected localFile
+}
+
+// NewLocalSnapshots creates LocalSnapshots loading the newest snapshot
+// from dir or, if dir is empty, the snapshot file at path.
+func NewLocalSnapshots(app *Application, path, dir string) *LocalSnapshots {
+	return &LocalSnapshots{app: app, path: path, dir: dir}
+}
+
+// SetSource changes the directory and the file snapshots are loaded from.
+func (l *LocalSnapshots) SetSource(path, dir string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.path, l.dir = path, dir
+}
+
+// MarkLoaded records that the live snapshot was loaded from path,
+// so that it's not loaded again until it changes.
+func (l *LocalSnapshots) MarkLoaded(path string) error {
+	f, err := statLocalFile(path)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.loaded = f
+	return nil
+}
+
+func (l *LocalSnapshots) source() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dir != "" {
+		return l.dir
+	}
+	return l.path
+}
+
+// Run loads the snapshot in a job if the file changed since it was last
+// loaded, waiting for the job's outcome.
+func (l *LocalSnapshots) Run(ctx context.Context) (string, error) {
+	job, _ := l.app.Jobs.Submit(JobTriggerWatch, l.source(), func() (UpdateDataResponse, error) {
+		return l.load(false)
+	})
+	return l.app.waitForJob(ctx, job)
+}
+
+// Reload loads the snapshot in a job even if the file hasn't changed. It's
+// never coalesced into a watch job, which would skip an unchanged file.
+func (l *LocalSnapshots) Reload(ctx context.Context) (string, error) {
+	job, _ := l.app.Jobs.Submit(JobTriggerReload, l.source(), func() (UpdateDataResponse, error) {
+		return l.load(true)
+	})
+	return l.app.waitForJob(ctx, job)
+}
+
+func statLocalFile(path string) (localFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return localFile{}, err
+	}
+	return localFile{path: path, modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
+
+// latest returns the file to load.
+func (l *LocalSnapshots) latest() (localFile, error) {
+	l.mu.Lock()
+	path, dir := l.path, l.dir
+	l.mu.Unlock()
+
+	if dir != "" {
+		var err error
+		path, _, err = eurostat.LatestSnapshotPath(dir)
+		if err != nil {
+			return localFile{}, err
+		}
+	}
+	if path == "" {
+		return localFile{}, errors.New("local snapshot path is not configured")
+	}
+	return statLocalFile(path)
+}
+
+func (l *LocalSnapshots) load(force bool) (UpdateDataResponse, error) {
+	app := l.app
+	live := app.Db.Timestamp()
+	unchanged := func(message string) (UpdateDataResponse, error) {
+		return UpdateDataResponse{Message: message, NotModified: true, SnapshotTimestamp: live}, nil
+	}
+
+	_, pinned, err := app.pinned()
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+	if pinned {
+		return unchanged("Snapshot is pinned, reload skipped.")
+	}
+
+	f, err := l.latest()
+	if errors.Is(err, eurostat.ErrNoSnapshotFiles) && !force {
+		return unchanged("No snapshot files found.")
+	}
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+
+	l.mu.Lock()
+	loaded, rejected := l.loaded, l.rejected
+	l.mu.Unlock()
+	if !force && (f == loaded || f == rejected) {
+		return unchanged("Snapshot file unchanged.")
+	}
+
+	reject := func(err error) (UpdateDataResponse, error) {
+		l.mu.Lock()
+		l.rejected = f
+		l.mu.Unlock()
+		return UpdateDataResponse{}, fmt.Errorf("snapshot file %s not loaded: %w", f.path, err)
+	}
+
+	snapshot, err := eurostat.DataSnapshotFromPath(f.path)
+	if err != nil {
+		return reject(err)
+	}
+	err = app.validate(snapshot)
+	if err != nil {
+		return reject(err)
+	}
+	err = app.loadSnapshot(snapshot)
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+
+	l.mu.Lock()
+	l.loaded = f
+	l.mu.Unlock()
+
+	log.Printf("Loaded snapshot %s from %s.\n", snapshot.Timestamp, f.path)
+	return UpdateDataResponse{
+		Message:           fmt.Sprintf("Successfully loaded snapshot for %s from %s.", snapshot.Timestamp, f.path),
+		SnapshotTimestamp: snapshot.Timestamp,
+	}, nil
+}
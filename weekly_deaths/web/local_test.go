@@ -0,0 +1,95 @@
+package web
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"weekly_deaths/eurostat"
+)
+
+func TestLocalSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	app := Application{Db: testingDB(), Jobs: NewJobs()}
+	local := NewLocalSnapshots(&app, "", dir)
+
+	write := func(name string, tsv string) string {
+		t.Helper()
+
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, gzipTSV(t, tsv), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	run := func(fn func(context.Context) (string, error), wantErr bool) {
+		t.Helper()
+
+		_, err := fn(context.Background())
+		if wantErr != (err != nil) {
+			t.Fatalf("expected error: %t but got %v", wantErr, err)
+		}
+	}
+
+	run(local.Run, false)
+	if !app.Db.Timestamp().Equal(testTimestamp()) {
+		t.Fatal("expected live snapshot to be kept without snapshot files")
+	}
+
+	first := write("20230102T030405.tsv.gz", "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t1")
+	err := local.MarkLoaded(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(local.Run, false)
+	if !app.Db.Timestamp().Equal(testTimestamp()) {
+		t.Fatal("expected file marked as loaded not to be loaded again")
+	}
+
+	// the newest file by name is loaded
+	write("20230109T030405.tsv.gz", "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t2")
+	write("20221226T030405.tsv.gz", "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t3")
+	run(local.Run, false)
+	if want := time.Date(2023, 1, 9, 3, 4, 5, 0, time.UTC); !app.Db.Timestamp().Equal(want) {
+		t.Fatalf("expected snapshot %s to be loaded but got %s", want, app.Db.Timestamp())
+	}
+
+	// file failing to load is skipped until it changes
+	broken := write("20230116T030405.tsv.gz", "")
+	run(local.Run, true)
+	run(local.Run, false)
+	err = os.WriteFile(broken, gzipTSV(t, "age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t4\nTOTAL,T,NR,DE\t5"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(local.Run, false)
+	got, _, err := app.Db.GetWeeklyDeaths("DE", "TOTAL", "T", 2021, 2021)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Deaths != 5 {
+		t.Fatalf("expected fixed snapshot file to be loaded but got %+v", got)
+	}
+
+	// reload loads the file even if it hasn't changed
+	app.Db = eurostat.DBFromSnapshot(eurostat.DataSnapshot{})
+	run(local.Run, false)
+	if !app.Db.Timestamp().IsZero() {
+		t.Fatal("expected unchanged file not to be loaded")
+	}
+	run(local.Reload, false)
+	if want := time.Date(2023, 1, 16, 3, 4, 5, 0, time.UTC); !app.Db.Timestamp().Equal(want) {
+		t.Fatalf("expected snapshot %s to be reloaded but got %s", want, app.Db.Timestamp())
+	}
+
+	// source can be changed to a single file
+	local.SetSource(first, "")
+	run(local.Reload, false)
+	if want := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC); !app.Db.Timestamp().Equal(want) {
+		t.Fatalf("expected snapshot %s from the new source but got %s", want, app.Db.Timestamp())
+	}
+}
@@ -4,15 +4,46 @@ import (
 	"time"
 
 	"weekly_deaths/eurostat"
+	"weekly_deaths/scheduler"
 )
 
 // WeeklyDeathsResponse represents a structure returned by
 // /api/weekly_deaths endpoint.
 type WeeklyDeathsResponse struct {
-	Gender       string                    `json:"gender"`
-	Age          string                    `json:"age"`
-	Country      string                    `json:"country"`
-	WeeklyDeaths []eurostat.WeekYearDeaths `json:"weekly_deaths"`
+	Gender            string                    `json:"gender"`
+	Age               string                    `json:"age"`
+	Country           string                    `json:"country"`
+	SnapshotTimestamp time.Time                 `json:"snapshot_timestamp"`
+	WeeklyDeaths      []eurostat.WeekYearDeaths `json:"weekly_deaths"`
+}
+
+// NowcastResponse represents a structure returned by
+// /api/nowcast endpoint.
+type NowcastResponse struct {
+	Gender            string                        `json:"gender"`
+	Age               string                        `json:"age"`
+	Country           string                        `json:"country"`
+	SnapshotTimestamp time.Time                     `json:"snapshot_timestamp"`
+	WeeklyDeaths      []eurostat.NowcastValue       `json:"weekly_deaths"`
+	Factors           []eurostat.CompletenessFactor `json:"completeness_factors"`
+}
+
+// CoverageResponse represents a structure returned by
+// /api/coverage endpoint.
+type CoverageResponse struct {
+	SnapshotTimestamp time.Time           `json:"snapshot_timestamp"`
+	Data              []eurostat.Coverage `json:"data"`
+}
+
+// RevisionsResponse represents a structure returned by
+// /api/revisions endpoint.
+type RevisionsResponse struct {
+	Gender    string              `json:"gender"`
+	Age       string              `json:"age"`
+	Country   string              `json:"country"`
+	Year      int                 `json:"year"`
+	Week      int                 `json:"week"`
+	Revisions []eurostat.Revision `json:"revisions"`
 }
 
 // MetadataLabel is a representation of label data
@@ -29,6 +60,61 @@ type MetadataLabel struct {
 // timestamp of downloading Eurostat data) returned by
 // /api/info endpoint.
 type InfoResponse struct {
-	CommitHash       string    `json:"commit_hash"`
+	CommitHash         string                `json:"commit_hash"`
+	DataDownloadedAt   time.Time             `json:"data_downloaded_at_utc_time"`
+	LastUpstreamCheck  *eurostat.FetchStatus `json:"last_upstream_check,omitempty"`
+	ScheduledRefresh   *scheduler.Status     `json:"scheduled_refresh,omitempty"`
+	SnapshotPickup     *scheduler.Status     `json:"snapshot_pickup,omitempty"`
+	LocalSnapshotWatch *scheduler.Status     `json:"local_snapshot_watch,omitempty"`
+	Leader             *eurostat.LeaseStatus `json:"leader,omitempty"`
+}
+
+// ReadyResponse is a representation of readiness
+// returned by /api/ready endpoint.
+type ReadyResponse struct {
+	Ready            bool      `json:"ready"`
 	DataDownloadedAt time.Time `json:"data_downloaded_at_utc_time"`
 }
+
+// UpdateDataResponse is a representation of the outcome
+// of data update returned by /api/update_data endpoint.
+type UpdateDataResponse struct {
+	Message           string    `json:"message"`
+	NotModified       bool      `json:"not_modified"`
+	Pinned            bool      `json:"pinned,omitempty"`
+	SnapshotTimestamp time.Time `json:"snapshot_timestamp"`
+}
+
+// JobResponse is a representation of data update job returned
+// by /api/update_data endpoint; Coalesced is true if the update
+// was already in progress.
+type JobResponse struct {
+	Job
+	Coalesced bool `json:"coalesced"`
+}
+
+// StoredSnapshot is a representation of stored snapshot
+// returned by /api/admin/snapshots endpoint.
+type StoredSnapshot struct {
+	eurostat.SnapshotManifest
+	Live   bool `json:"live"`
+	Pinned bool `json:"pinned"`
+}
+
+// SnapshotsResponse represents a structure returned by
+// /api/admin/snapshots endpoint.
+type SnapshotsResponse struct {
+	LiveTimestamp time.Time                   `json:"live_timestamp"`
+	Pinned        *eurostat.SnapshotPin       `json:"pinned,omitempty"`
+	Snapshots     []StoredSnapshot            `json:"snapshots"`
+	Quarantined   []eurostat.QuarantineReport `json:"quarantined"`
+}
+
+// UploadSnapshotResponse represents a structure returned after uploading
+// a snapshot; Stored is false if the same content was already stored
+// as the latest snapshot.
+type UploadSnapshotResponse struct {
+	Manifest eurostat.SnapshotManifest `json:"manifest"`
+	Stored   bool                      `json:"stored"`
+	Loaded   bool                      `json:"loaded"`
+}
@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"weekly_deaths/eurostat"
+)
+
+// pickupSource is the source of jobs loading snapshots from the store.
+const pickupSource = "snapshot store"
+
+// SnapshotPickup loads snapshots persisted in the shared snapshot store
+// by other instances, once they're newer than the live one. Every stored
+// snapshot is considered once: one failing validation is skipped until
+// a newer one appears, and so are snapshots stored before the live one
+// was rolled back to an older one.
+type SnapshotPickup struct {
+	app *Application
+
+	mu   sync.Mutex
+	seen time.Time
+}
+
+// NewSnapshotPickup creates SnapshotPickup for the application; snapshots
+// loaded through admin endpoints are reported to it.
+func NewSnapshotPickup(app *Application) *SnapshotPickup {
+	p := &SnapshotPickup{app: app}
+	app.pickup = p
+	return p
+}
+
+func (p *SnapshotPickup) markSeen(ts time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ts.After(p.seen) {
+		p.seen = ts
+	}
+}
+
+// Run checks the store for a newer snapshot and loads it in a job
+// (queued after other jobs, coalesced only with other pickups), waiting
+// for its outcome.
+func (p *SnapshotPickup) Run(ctx context.Context) (string, error) {
+	job, _ := p.app.Jobs.Submit(JobTriggerPickup, pickupSource, p.pickUp)
+	return p.app.waitForJob(ctx, job)
+}
+
+func (p *SnapshotPickup) pickUp() (UpdateDataResponse, error) {
+	app := p.app
+	if app.SnapshotManager == nil {
+		return UpdateDataResponse{}, errors.New("snapshot store is not configured")
+	}
+
+	live := app.Db.Timestamp()
+	unchanged := func(message string) (UpdateDataResponse, error) {
+		return UpdateDataResponse{Message: message, NotModified: true, SnapshotTimestamp: live}, nil
+	}
+
+	_, pinned, err := app.pinned()
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+	if pinned {
+		return unchanged("Snapshot is pinned, pickup skipped.")
+	}
+
+	timestamps, err := app.SnapshotManager.SnapshotTimestamps()
+	if errors.Is(err, eurostat.ErrNoParsableObjectsInBucket) {
+		return unchanged("No snapshots stored.")
+	}
+	if err != nil {
+		return UpdateDataResponse{}, fmt.Errorf("listing snapshots failed: %w", err)
+	}
+
+	latest := timestamps[len(timestamps)-1]
+	p.markSeen(live)
+	p.mu.Lock()
+	seen := p.seen
+	p.mu.Unlock()
+	if !latest.After(seen) {
+		return unchanged("No newer snapshot stored.")
+	}
+
+	snapshot, err := app.SnapshotManager.SnapshotAt(latest)
+	if err != nil {
+		return UpdateDataResponse{}, fmt.Errorf("reading snapshot %s failed: %w", latest, err)
+	}
+	p.markSeen(latest)
+
+	err = app.validate(snapshot)
+	if err != nil {
+		return UpdateDataResponse{}, fmt.Errorf("snapshot %s not loaded: %w", latest, err)
+	}
+
+	err = app.loadSnapshot(snapshot)
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+
+	// data published by Eurostat was already fetched by another instance
+	if app.Fetcher != nil {
+		err = app.Fetcher.LoadConditions(app.SnapshotManager)
+		if err != nil {
+			log.Printf("Reading conditions for the next fetch failed: %s\n", err)
+		}
+	}
+
+	log.Printf("Picked up snapshot %s from the snapshot store.\n", latest)
+	return UpdateDataResponse{
+		Message:           fmt.Sprintf("Successfully loaded snapshot for %s.", latest),
+		SnapshotTimestamp: latest,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"weekly_deaths/eurostat"
+)
+
+func TestSnapshotPickup(t *testing.T) {
+	sm := eurostat.NewSnapshotManagerWithStore(eurostat.NewMemorySnapshotStore())
+	app := Application{
+		Db:              testingDB(),
+		SnapshotManager: &sm,
+		Jobs:            NewJobs(),
+	}
+	pickup := NewSnapshotPickup(&app)
+
+	store := func(ts time.Time, rows int) {
+		t.Helper()
+
+		tsv := "age,sex,unit,geo\\time\t2021W01"
+		for i := 0; i < rows; i++ {
+			// content differs, so that it's not deduplicated
+			tsv += fmt.Sprintf("\nTOTAL,T,NR,C%d\t%d", i, ts.Unix()%1000+int64(i))
+		}
+		data, err := eurostat.ParseData(io.NopCloser(strings.NewReader(tsv)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := gzipTSV(t, tsv)
+		ds := eurostat.DataSnapshot{Data: data, Timestamp: ts}
+		_, err = sm.StoreSnapshot(raw, ds, eurostat.NewSnapshotManifest(raw, ds, "", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(wantErr bool) string {
+		t.Helper()
+
+		msg, err := pickup.Run(context.Background())
+		if wantErr != (err != nil) {
+			t.Fatalf("expected error: %t but got %v", wantErr, err)
+		}
+		return msg
+	}
+
+	run(false)
+	if !app.Db.Timestamp().Equal(testTimestamp()) {
+		t.Fatal("expected live snapshot to be kept with empty store")
+	}
+
+	older := testTimestamp().Add(-time.Hour)
+	store(older, 2)
+	run(false)
+	if !app.Db.Timestamp().Equal(testTimestamp()) {
+		t.Fatal("expected older snapshot not to be loaded")
+	}
+
+	newer := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	store(newer, 2)
+	run(false)
+	if !app.Db.Timestamp().Equal(newer) {
+		t.Fatalf("expected newer snapshot to be picked up but got %s", app.Db.Timestamp())
+	}
+
+	// snapshot failing validation is reported once
+	app.Validator = eurostat.ValidationRules{MinSeries: 2}.Against(app.Db)
+	invalid := newer.Add(time.Hour)
+	store(invalid, 1)
+	run(true)
+	run(false)
+	if !app.Db.Timestamp().Equal(newer) {
+		t.Fatal("expected invalid snapshot not to be loaded")
+	}
+
+	// pinned snapshot is kept
+	_, err := sm.Pin(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store(invalid.Add(time.Hour), 3)
+	run(false)
+	if !app.Db.Timestamp().Equal(newer) {
+		t.Fatal("expected pinned snapshot to be kept")
+	}
+
+	err = sm.Unpin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(false)
+	if !app.Db.Timestamp().Equal(invalid.Add(time.Hour)) {
+		t.Fatalf("expected the latest snapshot to be picked up but got %s", app.Db.Timestamp())
+	}
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"weekly_deaths/eurostat"
+)
+
+// ErrEmptySnapshot is returned when the fetched snapshot contains no series.
+var ErrEmptySnapshot = errors.New("fetched snapshot contains no data")
+
+// fetcher returns the configured fetcher or the default one.
+func (app *Application) fetcher() *eurostat.Fetcher {
+	if app.Fetcher != nil {
+		return app.Fetcher
+	}
+	return eurostat.NewFetcher()
+}
+
+// SubmitRefresh starts a job refreshing the data, or returns the job
+// that is already queued or running (coalesced is true then).
+func (app *Application) SubmitRefresh(trigger string) (job Job, coalesced bool) {
+	return app.Jobs.Submit(trigger, app.fetcher().URL(), app.RefreshData)
+}
+
+// ScheduledRefresh refreshes the data in a job and waits for its outcome.
+// With leader election only the leader refreshes the data, the other
+// instances pick up snapshots it persists.
+func (app *Application) ScheduledRefresh(ctx context.Context) (string, error) {
+	if app.Lease != nil && !app.Lease.IsLeader() {
+		status := app.Lease.Status()
+		return fmt.Sprintf("skipped, instance %s is not the leader (lease held by %q)", status.Instance, status.Holder), nil
+	}
+
+	job, _ := app.SubmitRefresh(JobTriggerScheduled)
+	return app.waitForJob(ctx, job)
+}
+
+// waitForJob waits until the job is finished and returns its outcome.
+func (app *Application) waitForJob(ctx context.Context, job Job) (string, error) {
+	job, err := app.Jobs.Wait(ctx, job.ID)
+	if err != nil {
+		return "", err
+	}
+	if job.State == JobFailed {
+		return "", errors.New(job.Error)
+	}
+	return job.Result.Message, nil
+}
+
+// RefreshData fetches the latest snapshot from Eurostat (unless it hasn't
+// changed since the last fetch or a snapshot is pinned), validates it
+// and loads it into the database and history indexes. It's run in jobs
+// started by /api/update_data endpoint and by the scheduled refresh.
+func (app *Application) RefreshData() (UpdateDataResponse, error) {
+	pin, ok, err := app.pinned()
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+	if ok {
+		log.Println("Data update skipped, snapshot is pinned.")
+		return UpdateDataResponse{
+			Message:           fmt.Sprintf("Snapshot for %s is pinned, data update skipped.", pin.Timestamp),
+			Pinned:            true,
+			SnapshotTimestamp: app.Db.Timestamp(),
+		}, nil
+	}
+
+	snapshot, err := app.fetcher().FetchIfModified()
+	if errors.Is(err, eurostat.ErrNotModified) {
+		log.Println("Data update skipped, Eurostat has not published new data.")
+		return UpdateDataResponse{
+			Message:           "Eurostat has not published new data since the last fetch.",
+			NotModified:       true,
+			SnapshotTimestamp: app.Db.Timestamp(),
+		}, nil
+	}
+	if err != nil {
+		return UpdateDataResponse{}, fmt.Errorf("fetching data from Eurostat failed: %w", err)
+	}
+	if len(snapshot.Data) == 0 {
+		return UpdateDataResponse{}, fmt.Errorf("validating snapshot failed: %w", ErrEmptySnapshot)
+	}
+
+	err = app.loadSnapshot(snapshot)
+	if err != nil {
+		return UpdateDataResponse{}, err
+	}
+
+	log.Println("Data update succeeded.")
+	return UpdateDataResponse{
+		Message:           fmt.Sprintf("Successfully loaded snapshot for %s.", snapshot.Timestamp),
+		SnapshotTimestamp: snapshot.Timestamp,
+	}, nil
+}
+
+// pinned returns the pinned snapshot; false if there's none
+// or the snapshot store is not configured.
+func (app *Application) pinned() (eurostat.SnapshotPin, bool, error) {
+	if app.SnapshotManager == nil {
+		return eurostat.SnapshotPin{}, false, nil
+	}
+
+	pin, ok, err := app.SnapshotManager.Pinned()
+	if err != nil {
+		return pin, false, fmt.Errorf("reading pinned snapshot: %w", err)
+	}
+	return pin, ok, nil
+}
+
+// loadSnapshot makes the snapshot live and adds it to history indexes.
+func (app *Application) loadSnapshot(snapshot eurostat.DataSnapshot) error {
+	err := app.Db.LoadSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("loading snapshot failed: %w", err)
+	}
+	if app.Nowcaster != nil {
+		err = app.Nowcaster.Add(snapshot)
+		if err != nil {
+			log.Printf("Updating nowcast factors failed: %s\n", err)
+		}
+	}
+	if app.Revisions != nil {
+		// index only grows forward, snapshots loaded by rollbacks and
+		// pins are older than the latest indexed one
+		err = app.Revisions.Add(snapshot)
+		if err != nil && !errors.Is(err, eurostat.ErrOutdatedSnapshot) {
+			log.Printf("Updating revision index failed: %s\n", err)
+		}
+	}
+	return nil
+}
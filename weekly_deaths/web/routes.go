@@ -2,24 +2,45 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 	"weekly_deaths/eurostat"
+	"weekly_deaths/scheduler"
 
 	"github.com/go-chi/chi/v5"
 )
 
 const paramRequiredUserMessage = "This query url parameter is required."
 const failedConversionToIntMessage = "Provided value cannot be converted to integer."
+const failedConversionToTimestampMessage = "Provided value cannot be converted to timestamp (RFC 3339, YYYYMMDDTHHMMSS or YYYY-MM-DD expected)."
 
 const errorMessageKey = "message"
 
 type Application struct {
-	Db   *eurostat.InMemoryDB
-	Auth struct {
+	Db        eurostat.Store
+	Nowcaster *eurostat.Nowcaster
+	Revisions *eurostat.RevisionIndex
+	Coverage  *eurostat.CoverageCache
+	// SnapshotManager manages the snapshot store in admin endpoints, diffs and snapshot pickup.
+	SnapshotManager *eurostat.SnapshotManager
+	// Validator checks snapshots uploaded through admin endpoints or picked up from the store.
+	Validator eurostat.SnapshotValidator
+	Fetcher   *eurostat.Fetcher
+	Scheduler *scheduler.Scheduler
+	// PickupSchedule polls the snapshot store for snapshots persisted by other instances.
+	PickupSchedule *scheduler.Scheduler
+	pickup         *SnapshotPickup
+	// LocalWatch polls the local snapshot directory for new snapshot files.
+	LocalWatch *scheduler.Scheduler
+	// Lease elects the instance running scheduled refreshes, if set.
+	Lease *eurostat.Lease
+	Jobs  *Jobs
+	Auth  struct {
 		Username string
 		Password string
 	}
@@ -28,10 +49,28 @@ type Application struct {
 func (app *Application) Routes() *chi.Mux {
 	router := chi.NewRouter()
 
+	if app.Jobs == nil {
+		app.Jobs = NewJobs()
+	}
+
 	router.Get("/api/weekly_deaths", app.WeeklyDeathsHandler)
+	router.Get("/api/nowcast", app.NowcastHandler)
+	router.Get("/api/coverage", app.CoverageHandler)
+	router.Get("/api/revisions", app.RevisionsHandler)
+	router.Get("/api/dimensions", app.DimensionsHandler)
 	router.Get("/api/labels", app.LabelsHandler)
 	router.Get("/api/info", app.InfoHandler)
+	router.Get("/api/ready", app.ReadyHandler)
 	router.Post("/api/update_data", app.basicAuth(app.UpdateDataHandler))
+	router.Get("/api/jobs/{id}", app.basicAuth(app.JobHandler))
+	router.Get("/api/admin/diff", app.basicAuth(app.DiffHandler))
+	router.Get("/api/admin/snapshots", app.basicAuth(app.SnapshotsHandler))
+	router.Post("/api/admin/snapshots", app.basicAuth(app.UploadSnapshotHandler))
+	router.Delete("/api/admin/snapshots/{timestamp}", app.basicAuth(app.DeleteSnapshotHandler))
+	router.Post("/api/admin/snapshots/{timestamp}/load", app.basicAuth(app.LoadSnapshotHandler))
+	router.Post("/api/admin/snapshots/{timestamp}/pin", app.basicAuth(app.PinSnapshotHandler))
+	router.Delete("/api/admin/pin", app.basicAuth(app.UnpinSnapshotHandler))
+	router.Post("/api/admin/retention", app.basicAuth(app.RetentionHandler))
 	return router
 }
 
@@ -41,36 +80,30 @@ type WeeklyDeathsRequest struct {
 	gender   string
 	yearFrom int
 	yearTo   int
+	asOf     time.Time
 }
 
-// WeeklyDeathsHandler is a HTTP handler func exposing
-// Eurostat weekly deaths data for given parameters:
-// - country
-// - gender
-// - age
-// - year_from
-// - year_to
-// All parameters are required and should be passed as query params.
-func (app *Application) WeeklyDeathsHandler(w http.ResponseWriter, r *http.Request) {
+// parseWeeklyDeathsRequest extracts and validates weekly deaths
+// query params. Returned slice contains validation errors (if any).
+func parseWeeklyDeathsRequest(r *http.Request) (WeeklyDeathsRequest, []map[string]string) {
 	var (
-		yearFrom int
-		yearTo   int
-		err      error
+		req WeeklyDeathsRequest
+		err error
 	)
 	errors := make([]map[string]string, 0)
 
-	country := r.URL.Query().Get("country")
-	if country == "" {
+	req.country = r.URL.Query().Get("country")
+	if req.country == "" {
 		errors = append(errors, map[string]string{"field": "country", errorMessageKey: paramRequiredUserMessage})
 	}
 
-	gender := r.URL.Query().Get("gender")
-	if gender == "" {
+	req.gender = r.URL.Query().Get("gender")
+	if req.gender == "" {
 		errors = append(errors, map[string]string{"field": "gender", errorMessageKey: paramRequiredUserMessage})
 	}
 
-	age := r.URL.Query().Get("age")
-	if age == "" {
+	req.age = r.URL.Query().Get("age")
+	if req.age == "" {
 		errors = append(errors, map[string]string{"field": "age", errorMessageKey: paramRequiredUserMessage})
 	}
 
@@ -78,7 +111,7 @@ func (app *Application) WeeklyDeathsHandler(w http.ResponseWriter, r *http.Reque
 	if yearFromStr == "" {
 		errors = append(errors, map[string]string{"field": "year_from", errorMessageKey: paramRequiredUserMessage})
 	} else {
-		yearFrom, err = strconv.Atoi(yearFromStr)
+		req.yearFrom, err = strconv.Atoi(yearFromStr)
 		if err != nil {
 			errors = append(errors, map[string]string{"field": "year_from", errorMessageKey: failedConversionToIntMessage})
 		}
@@ -88,35 +121,270 @@ func (app *Application) WeeklyDeathsHandler(w http.ResponseWriter, r *http.Reque
 	if yearToStr == "" {
 		errors = append(errors, map[string]string{"field": "year_to", errorMessageKey: paramRequiredUserMessage})
 	} else {
-		yearTo, err = strconv.Atoi(yearToStr)
+		req.yearTo, err = strconv.Atoi(yearToStr)
 		if err != nil {
 			errors = append(errors, map[string]string{"field": "year_to", errorMessageKey: failedConversionToIntMessage})
 		}
 	}
 
+	req.asOf, errors = parseAsOf(r, errors)
+	return req, errors
+}
+
+// parseAsOf extracts optional as_of query param, appending
+// validation error (if any) to errors.
+func parseAsOf(r *http.Request, errors []map[string]string) (time.Time, []map[string]string) {
+	v := r.URL.Query().Get("as_of")
+	if v == "" {
+		return time.Time{}, errors
+	}
+
+	asOf, err := parseTimestampParam(v)
+	if err != nil {
+		errors = append(errors, map[string]string{"field": "as_of", errorMessageKey: failedConversionToTimestampMessage})
+	}
+	return asOf, errors
+}
+
+// snapshotAsOf returns the snapshot that should be used to serve
+// the request with given as_of value (zero value means latest data).
+func (app *Application) snapshotAsOf(asOf time.Time) (eurostat.DataSnapshot, error) {
+	if asOf.IsZero() {
+		return app.Db.Snapshot()
+	}
+
+	hs, ok := app.Db.(eurostat.HistoryStore)
+	if !ok {
+		return eurostat.DataSnapshot{}, eurostat.ErrHistoryNotSupported
+	}
+	return hs.SnapshotAsOf(asOf)
+}
+
+// weeklyDeaths returns data requested by req along with the timestamp
+// of the snapshot it was served from.
+func (app *Application) weeklyDeaths(req WeeklyDeathsRequest) ([]eurostat.WeekYearDeaths, time.Time, error) {
+	if req.asOf.IsZero() {
+		return app.Db.GetWeeklyDeaths(req.country, req.age, req.gender, req.yearFrom, req.yearTo)
+	}
+
+	hs, ok := app.Db.(eurostat.HistoryStore)
+	if !ok {
+		return nil, time.Time{}, eurostat.ErrHistoryNotSupported
+	}
+	return hs.GetWeeklyDeathsAsOf(req.asOf, req.country, req.age, req.gender, req.yearFrom, req.yearTo)
+}
+
+// writeSnapshotError writes response for errors returned when
+// fetching the data (possibly from an older snapshot for as_of query).
+func writeSnapshotError(w http.ResponseWriter, err error) {
+	if errors.Is(err, eurostat.ErrNoSnapshotAsOf) {
+		_ = writeJSONError(http.StatusNotFound, w, err.Error())
+		return
+	}
+
+	if errors.Is(err, eurostat.ErrHistoryNotSupported) {
+		_ = writeJSONError(http.StatusNotImplemented, w, err.Error())
+		return
+	}
+
+	log.Printf("Fetching data failed: %s\n", err)
+	_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+}
+
+// WeeklyDeathsHandler is a HTTP handler func exposing
+// Eurostat weekly deaths data for given parameters:
+// - country
+// - gender
+// - age
+// - year_from
+// - year_to
+// All parameters are required and should be passed as query params.
+// Optional as_of parameter makes the data served from the closest
+// snapshot that is not later than given timestamp.
+func (app *Application) WeeklyDeathsHandler(w http.ResponseWriter, r *http.Request) {
+	req, errors := parseWeeklyDeathsRequest(r)
 	if len(errors) > 0 {
 		_ = writeJSONError(http.StatusBadRequest, w, errors)
 		return
 	}
 
-	weeklyDeaths, err := app.Db.GetWeeklyDeaths(
-		country,
-		age,
-		gender,
-		yearFrom,
-		yearTo,
-	)
+	weeklyDeaths, snapshotTimestamp, err := app.weeklyDeaths(req)
 	if err != nil {
-		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		writeSnapshotError(w, err)
 		return
 	}
 
-	data := WeeklyDeathsResponse{Gender: gender, Age: age, Country: country, WeeklyDeaths: weeklyDeaths}
+	data := WeeklyDeathsResponse{
+		Gender:            req.gender,
+		Age:               req.age,
+		Country:           req.country,
+		SnapshotTimestamp: snapshotTimestamp,
+		WeeklyDeaths:      weeklyDeaths,
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(data)
 }
 
+// NowcastHandler is an HTTP handler returning weekly deaths (same parameters
+// as WeeklyDeathsHandler) along with values corrected for reporting delays
+// of the most recent weeks and completeness factors used for the correction.
+func (app *Application) NowcastHandler(w http.ResponseWriter, r *http.Request) {
+	if app.Nowcaster == nil {
+		_ = writeJSONError(http.StatusServiceUnavailable, w, "nowcasting is not enabled")
+		return
+	}
+
+	req, errors := parseWeeklyDeathsRequest(r)
+	if len(errors) > 0 {
+		_ = writeJSONError(http.StatusBadRequest, w, errors)
+		return
+	}
+
+	weeklyDeaths, timestamp, err := app.weeklyDeaths(req)
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+
+	factors := app.Nowcaster.Factors(req.country)
+	if factors == nil {
+		factors = make([]eurostat.CompletenessFactor, 0)
+	}
+
+	_ = writeJSON(http.StatusOK, w, NowcastResponse{
+		Gender:            req.gender,
+		Age:               req.age,
+		Country:           req.country,
+		SnapshotTimestamp: timestamp,
+		WeeklyDeaths:      app.Nowcaster.Apply(req.country, timestamp, weeklyDeaths),
+		Factors:           factors,
+	})
+}
+
+// CoverageHandler is an HTTP handler returning data completeness
+// of the loaded snapshot: first and last reported week, missing weeks,
+// share of provisional values and reporting lag. By default, coverage
+// is computed per country (for TOTAL age and T gender series).
+// Optional query params:
+// - country (limits results to a single country)
+// - age, gender (select series other than totals)
+// - breakdown=true (returns coverage of every age/gender series)
+// - as_of (computes coverage of the closest earlier snapshot)
+func (app *Application) CoverageHandler(w http.ResponseWriter, r *http.Request) {
+	asOf, errors := parseAsOf(r, make([]map[string]string, 0))
+	if len(errors) > 0 {
+		_ = writeJSONError(http.StatusBadRequest, w, errors)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := eurostat.CoverageFilter{
+		Country: query.Get("country"),
+		Age:     query.Get("age"),
+		Gender:  query.Get("gender"),
+	}
+
+	if query.Get("breakdown") != "true" {
+		if filter.Age == "" {
+			filter.Age = "TOTAL"
+		}
+		if filter.Gender == "" {
+			filter.Gender = "T"
+		}
+	}
+
+	// coverage of the live snapshot is cached, older ones are computed on request
+	var cache *eurostat.CoverageCache
+	timestamp := asOf
+	if asOf.IsZero() {
+		cache, timestamp = app.Coverage, app.Db.Timestamp()
+	}
+
+	coverage, timestamp, err := cache.Coverage(timestamp, func() (eurostat.DataSnapshot, error) {
+		return app.snapshotAsOf(asOf)
+	}, filter)
+	if err != nil {
+		writeSnapshotError(w, err)
+		return
+	}
+
+	_ = writeJSON(http.StatusOK, w, CoverageResponse{
+		SnapshotTimestamp: timestamp,
+		Data:              coverage,
+	})
+}
+
+// RevisionsHandler is an HTTP handler returning every value published
+// across stored snapshots for a single week of a series. Parameters:
+// - country
+// - gender
+// - age
+// - year
+// - week
+// All parameters are required and should be passed as query params.
+func (app *Application) RevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.Revisions == nil {
+		_ = writeJSONError(http.StatusServiceUnavailable, w, "revision history is not enabled")
+		return
+	}
+
+	errors := make([]map[string]string, 0)
+	params := make(map[string]string)
+	for _, p := range []string{"country", "gender", "age", "year", "week"} {
+		params[p] = r.URL.Query().Get(p)
+		if params[p] == "" {
+			errors = append(errors, map[string]string{"field": p, errorMessageKey: paramRequiredUserMessage})
+		}
+	}
+
+	ints := make(map[string]int)
+	for _, p := range []string{"year", "week"} {
+		if params[p] == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(params[p])
+		if err != nil {
+			errors = append(errors, map[string]string{"field": p, errorMessageKey: failedConversionToIntMessage})
+		}
+		ints[p] = v
+	}
+
+	if len(errors) > 0 {
+		_ = writeJSONError(http.StatusBadRequest, w, errors)
+		return
+	}
+
+	revisions, err := app.Revisions.Revisions(params["country"], params["age"], params["gender"], ints["year"], ints["week"])
+	if err != nil {
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
+	}
+
+	_ = writeJSON(http.StatusOK, w, RevisionsResponse{
+		Gender:    params["gender"],
+		Age:       params["age"],
+		Country:   params["country"],
+		Year:      ints["year"],
+		Week:      ints["week"],
+		Revisions: revisions,
+	})
+}
+
+// DimensionsHandler is an HTTP handler returning countries, age groups,
+// genders and range of years available in the loaded data.
+func (app *Application) DimensionsHandler(w http.ResponseWriter, r *http.Request) {
+	dims, err := app.Db.Dimensions()
+	if err != nil {
+		log.Printf("Fetching dimensions failed: %s\n", err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
+	}
+
+	_ = writeJSON(http.StatusOK, w, dims)
+}
+
 // LabelsHandler is an HTTP handler returning labels translation
 // for countries, genders and age groups used in weekly deaths dataset.
 func (app *Application) LabelsHandler(w http.ResponseWriter, r *http.Request) {
@@ -127,25 +395,139 @@ func (app *Application) LabelsHandler(w http.ResponseWriter, r *http.Request) {
 // InfoHandler is an HTTP handler returning metadata about the application:
 // - the commit from which currently running instance was built
 // - timestamp indicating when the data was downloaded from Eurostat
+// - last upstream check, scheduled refresh and snapshot pickup runs (if enabled)
 func (app *Application) InfoHandler(w http.ResponseWriter, r *http.Request) {
-	writeJSON(http.StatusOK, w, InfoResponse{
+	info := InfoResponse{
 		CommitHash:       os.Getenv("COMMIT"),
 		DataDownloadedAt: app.Db.Timestamp(),
-	})
+	}
+	if app.Fetcher != nil {
+		info.LastUpstreamCheck = app.Fetcher.Status()
+	}
+	if app.Scheduler != nil {
+		status := app.Scheduler.Status()
+		info.ScheduledRefresh = &status
+	}
+	if app.PickupSchedule != nil {
+		status := app.PickupSchedule.Status()
+		info.SnapshotPickup = &status
+	}
+	if app.LocalWatch != nil {
+		status := app.LocalWatch.Status()
+		info.LocalSnapshotWatch = &status
+	}
+	if app.Lease != nil {
+		status := app.Lease.Status()
+		info.Leader = &status
+	}
+	writeJSON(http.StatusOK, w, info)
+}
+
+// ReadyHandler is an HTTP handler for readiness probes; it responds
+// with 503 Service Unavailable until any data is loaded.
+func (app *Application) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ts := app.Db.Timestamp()
+	if ts.IsZero() {
+		writeJSON(http.StatusServiceUnavailable, w, ReadyResponse{})
+		return
+	}
+	writeJSON(http.StatusOK, w, ReadyResponse{Ready: true, DataDownloadedAt: ts})
 }
 
+// UpdateDataHandler is an HTTP handler starting a job that refreshes
+// the data (or returning the one already in progress). Its status
+// is available under the returned Location.
 func (app *Application) UpdateDataHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Received request for data update.")
-	snapshot, err := eurostat.DataSnapshotFromEurostat()
+	job, coalesced := app.SubmitRefresh(JobTriggerManual)
+	if coalesced {
+		log.Printf("Data update request coalesced into job %s.\n", job.ID)
+	}
+
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	writeJSON(http.StatusAccepted, w, JobResponse{Job: job, Coalesced: coalesced})
+}
+
+// JobHandler is an HTTP handler returning state, timings, source
+// and outcome of a data update job.
+func (app *Application) JobHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	job, ok := app.Jobs.Get(id)
+	if !ok {
+		_ = writeJSONError(http.StatusNotFound, w, fmt.Sprintf("job %s not found", id))
+		return
+	}
+
+	writeJSON(http.StatusOK, w, job)
+}
+
+// DiffHandler is an HTTP handler comparing a stored snapshot with the live
+// one: changed values, added and removed series. Query params:
+// - snapshot (timestamp of the stored snapshot)
+// - summary=true (returns only numbers of differences)
+func (app *Application) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+
+	v := r.URL.Query().Get("snapshot")
+	if v == "" {
+		_ = writeJSONError(http.StatusBadRequest, w, []map[string]string{{"field": "snapshot", errorMessageKey: paramRequiredUserMessage}})
+		return
+	}
+
+	ts, err := parseTimestampParam(v)
+	if err != nil {
+		_ = writeJSONError(http.StatusBadRequest, w, []map[string]string{{"field": "snapshot", errorMessageKey: failedConversionToTimestampMessage}})
+		return
+	}
+
+	timestamps, err := sm.SnapshotTimestamps()
+	if err != nil {
+		log.Printf("Listing snapshots failed: %s\n", err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
+	}
+
+	found := false
+	for _, t := range timestamps {
+		if t.Equal(ts) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		_ = writeJSONError(http.StatusNotFound, w, fmt.Sprintf("snapshot %s not found", v))
+		return
+	}
+
+	stored, err := sm.SnapshotAt(ts)
+	if err != nil {
+		log.Printf("Loading snapshot %s failed: %s\n", v, err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
+	}
+
+	live, err := app.Db.Snapshot()
 	if err != nil {
-		log.Printf("Data update failed: %s\n", err)
-		writeJSONError(http.StatusInternalServerError, w, fmt.Sprintf("Fetching data from Eurostat failed: %s", err))
+		log.Printf("Reading live snapshot failed: %s\n", err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
 	}
 
-	app.Db.LoadSnapshot(snapshot)
-	log.Println("Data update succeeded.")
-	msg := fmt.Sprintf("Successfully loaded snapshot for %s.", snapshot.Timestamp)
-	writeJSON(http.StatusOK, w, map[string]string{"message": msg})
+	diff, err := eurostat.DiffSnapshots(stored, live)
+	if err != nil {
+		log.Printf("Comparing snapshots failed: %s\n", err)
+		_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+		return
+	}
+
+	if r.URL.Query().Get("summary") == "true" {
+		_ = writeJSON(http.StatusOK, w, diff.Summary())
+		return
+	}
+	_ = writeJSON(http.StatusOK, w, diff)
 }
 
 func (app *Application) NotFound(w http.ResponseWriter, r *http.Request) {
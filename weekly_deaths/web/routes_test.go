@@ -1,6 +1,9 @@
 package web
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -97,6 +100,38 @@ func TestInfoHandler(t *testing.T) {
 	}
 }
 
+func TestReadyHandler(t *testing.T) {
+	cases := []struct {
+		db         eurostat.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			db:         eurostat.DBFromSnapshot(eurostat.DataSnapshot{}),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"ready":false,"data_downloaded_at_utc_time":"0001-01-01T00:00:00Z"}`,
+		},
+		{
+			db:         testingDB(),
+			wantStatus: http.StatusOK,
+			wantBody:   `{"ready":true,"data_downloaded_at_utc_time":"2021-01-12T10:23:11Z"}`,
+		},
+	}
+
+	for _, c := range cases {
+		app := Application{Db: c.db}
+		rr := httptest.NewRecorder()
+		app.Routes().ServeHTTP(rr, httptest.NewRequest("GET", "/api/ready", nil))
+
+		if rr.Code != c.wantStatus {
+			t.Errorf("handler returned wrong status code: got %d want %d", rr.Code, c.wantStatus)
+		}
+		if body := strings.TrimSuffix(rr.Body.String(), "\n"); body != c.wantBody {
+			t.Errorf("handler returned unexpected body: got %s want %s", body, c.wantBody)
+		}
+	}
+}
+
 func TestWeeklyDeathsHandlerWithIncorrectYearRange(t *testing.T) {
 	var resp WeeklyDeathsResponse
 
@@ -351,3 +386,335 @@ func TestWeeklyDeathsHandlerMissingQueryParams(t *testing.T) {
 		}
 	}
 }
+
+func TestNowcastHandlerWhenNowcastingDisabled(t *testing.T) {
+	req, err := http.NewRequest("GET", "?country=PL&age=TOTAL&gender=T&year_from=2021&year_to=2021", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB()}
+	handler := http.HandlerFunc(app.NowcastHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %d want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestNowcastHandler(t *testing.T) {
+	var resp NowcastResponse
+
+	req, err := http.NewRequest("GET", "?country=PL&age=TOTAL&gender=T&year_from=2021&year_to=2021", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB(), Nowcaster: eurostat.NewNowcaster(eurostat.DefaultNowcastMaxLag)}
+	handler := http.HandlerFunc(app.NowcastHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.WeeklyDeaths) != 4 {
+		t.Fatalf("expected 4 weekly values but got %+v", resp.WeeklyDeaths)
+	}
+
+	for _, v := range resp.WeeklyDeaths {
+		if v.Nowcast != float64(v.Deaths) {
+			t.Fatalf("expected unchanged values without estimated factors but got %+v", v)
+		}
+	}
+}
+
+func TestCoverageHandler(t *testing.T) {
+	var resp CoverageResponse
+
+	req, err := http.NewRequest("GET", "?country=PL", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB(), Coverage: eurostat.NewCoverageCache()}
+	handler := http.HandlerFunc(app.CoverageHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Data) != 1 {
+		t.Fatalf("expected coverage of a single series but got %+v", resp.Data)
+	}
+
+	if got := resp.Data[0]; got.FirstWeek != "2020W01" || got.LastWeek != "2022W04" {
+		t.Fatalf("unexpected coverage: %+v", got)
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("handler returned unexpected content-type: got %s want %s", contentType, expectedContentType)
+	}
+}
+
+func TestRevisionsHandlerMissingQueryParams(t *testing.T) {
+	var resp errorResponse
+
+	req, err := http.NewRequest("GET", "?country=PL&age=TOTAL&gender=T&year=2021&week=x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB(), Revisions: eurostat.NewRevisionIndex()}
+	handler := http.HandlerFunc(app.RevisionsHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %d want %d", status, http.StatusBadRequest)
+	}
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []fieldError{{Field: "week", Message: failedConversionToIntMessage}}
+	if !reflect.DeepEqual(want, resp["error"]) {
+		t.Fatalf("expected %+v but got %+v", want, resp["error"])
+	}
+}
+
+func TestRevisionsHandler(t *testing.T) {
+	var resp RevisionsResponse
+
+	req, err := http.NewRequest("GET", "?country=PL&age=TOTAL&gender=T&year=2021&week=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions := eurostat.NewRevisionIndex()
+	snapshot, err := testingDB().Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = revisions.Add(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB(), Revisions: revisions}
+	handler := http.HandlerFunc(app.RevisionsHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []eurostat.Revision{{SnapshotTimestamp: testTimestamp(), Deaths: 10}}
+	if !reflect.DeepEqual(want, resp.Revisions) {
+		t.Fatalf("expected %+v but got %+v", want, resp.Revisions)
+	}
+}
+
+func TestWeeklyDeathsHandlerAsOfBeforeLoadedSnapshot(t *testing.T) {
+	req, err := http.NewRequest("GET", "?country=PL&age=TOTAL&gender=T&year_from=2021&year_to=2021&as_of=2020-01-01", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB()}
+	handler := http.HandlerFunc(app.WeeklyDeathsHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %d want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestWeeklyDeathsHandlerAsOf(t *testing.T) {
+	var resp WeeklyDeathsResponse
+
+	req, err := http.NewRequest("GET", "?country=PL&age=TOTAL&gender=T&year_from=2021&year_to=2021&as_of=2022-01-01T00:00:00Z", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB()}
+	handler := http.HandlerFunc(app.WeeklyDeathsHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !resp.SnapshotTimestamp.Equal(testTimestamp()) {
+		t.Fatalf("expected snapshot timestamp %s but got %v", testTimestamp(), resp.SnapshotTimestamp)
+	}
+
+	if len(resp.WeeklyDeaths) != 4 {
+		t.Fatalf("expected 4 weekly values but got %+v", resp.WeeklyDeaths)
+	}
+}
+
+func TestDiffHandler(t *testing.T) {
+	var resp eurostat.DiffSummary
+
+	sm := eurostat.NewSnapshotManagerWithStore(eurostat.NewMemorySnapshotStore())
+	raw := gzipTSV(t, "age,sex,unit,geo\\time\t2021W02\t2021W01\nTOTAL,T,NR,PL\t11\t5")
+	err := sm.PersistSnapshot(bytes.NewReader(raw), testTimestamp().AddDate(0, 0, -7))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := Application{Db: testingDB(), SnapshotManager: &sm}
+	handler := http.HandlerFunc(app.DiffHandler)
+
+	req, err := http.NewRequest("GET", "?summary=true&snapshot=20210105T102311", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ChangedValues != 1 || resp.AddedSeries != 3 || resp.RemovedSeries != 0 {
+		t.Fatalf("unexpected diff summary: %+v", resp)
+	}
+
+	req, err = http.NewRequest("GET", "?snapshot=20200105T102311", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %d want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestUpdateDataHandler(t *testing.T) {
+	var raw bytes.Buffer
+	zw := gzip.NewWriter(&raw)
+	zw.Write([]byte("age,sex,unit,geo\\time\t2021W01\nTOTAL,T,NR,PL\t42"))
+	zw.Close()
+
+	release := make(chan struct{})
+	failing := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(raw.Bytes())
+	}))
+	defer upstream.Close()
+
+	app := Application{
+		Db:      testingDB(),
+		Fetcher: eurostat.NewFetcherWithOptions(eurostat.FetcherOptions{URL: upstream.URL, Attempts: 1}),
+	}
+	app.Auth.Username = testUsername
+	app.Auth.Password = testPassword
+	router := app.Routes()
+
+	serve := func(method, url string, wantCode int, v any) {
+		t.Helper()
+
+		req := httptest.NewRequest(method, url, nil)
+		req.SetBasicAuth(testUsername, testPassword)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != wantCode {
+			t.Fatalf("%s %s: expected status %d but got %d: %s", method, url, wantCode, rr.Code, rr.Body.String())
+		}
+
+		err := json.NewDecoder(rr.Body).Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := func() Job {
+		t.Helper()
+
+		var first, second JobResponse
+		serve("POST", "/api/update_data", http.StatusAccepted, &first)
+		serve("POST", "/api/update_data", http.StatusAccepted, &second)
+		if first.Coalesced || !second.Coalesced || first.ID != second.ID {
+			t.Fatalf("expected concurrent updates to be coalesced but got %+v and %+v", first, second)
+		}
+		if first.Source != upstream.URL || first.Trigger != JobTriggerManual || first.Finished() {
+			t.Fatalf("unexpected job %+v", first)
+		}
+
+		release <- struct{}{}
+		job, err := app.Jobs.Wait(context.Background(), first.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got Job
+		serve("GET", "/api/jobs/"+first.ID, http.StatusOK, &got)
+		if !reflect.DeepEqual(job, got) || got.StartedAt == nil || got.FinishedAt == nil {
+			t.Fatalf("expected finished job %+v but got %+v", job, got)
+		}
+		return got
+	}
+
+	job := update()
+	if job.State != JobSucceeded || job.Result.NotModified {
+		t.Fatalf("expected new data to be loaded but got %+v", job)
+	}
+	got, _, _ := app.Db.GetWeeklyDeaths("PL", "TOTAL", "T", 2021, 2021)
+	if len(got) != 1 || got[0].Deaths != 42 {
+		t.Fatalf("expected updated data but got %+v", got)
+	}
+
+	job = update()
+	if job.State != JobSucceeded || !job.Result.NotModified || !job.Result.SnapshotTimestamp.Equal(app.Db.Timestamp()) {
+		t.Fatalf("expected not modified response but got %+v", job)
+	}
+
+	failing = true
+	job = update()
+	if job.State != JobFailed || job.Error == "" || job.Result != nil {
+		t.Fatalf("expected failed job but got %+v", job)
+	}
+	got, _, _ = app.Db.GetWeeklyDeaths("PL", "TOTAL", "T", 2021, 2021)
+	if len(got) != 1 || got[0].Deaths != 42 {
+		t.Fatalf("expected data to be kept after failed update but got %+v", got)
+	}
+
+	var info InfoResponse
+	serve("GET", "/api/info", http.StatusOK, &info)
+	if info.LastUpstreamCheck == nil || info.LastUpstreamCheck.Error == "" {
+		t.Fatalf("expected info about failed check but got %+v", info.LastUpstreamCheck)
+	}
+
+	var notFound map[string]any
+	serve("GET", "/api/jobs/missing", http.StatusNotFound, &notFound)
+}
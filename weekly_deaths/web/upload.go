@@ -0,0 +1,178 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"weekly_deaths/eurostat"
+)
+
+// uploadMemoryLimit is the part of multipart form kept in memory
+// (the rest is stored in temporary files).
+const uploadMemoryLimit = 32 << 20
+
+// uploadSourcePrefix prefixes file names used as source
+// in manifests of uploaded snapshots.
+const uploadSourcePrefix = "upload:"
+
+var errUnsupportedSnapshotFile = errors.New("unsupported snapshot file, .tsv.gz or .tsv expected")
+
+// readUploadedSnapshot returns gzipped raw data (as stored in the snapshot
+// store) and parsed data of the uploaded file. Plain TSV files are gzipped.
+func readUploadedSnapshot(r io.Reader, filename string) ([]byte, map[string][]eurostat.WeeklyDeaths, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := strings.ToLower(path.Base(filename))
+	switch {
+	case strings.HasSuffix(name, ".tsv.gz"), strings.HasSuffix(name, ".gz"):
+	case strings.HasSuffix(name, ".tsv"):
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err = zw.Write(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = zw.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		b = buf.Bytes()
+	default:
+		return nil, nil, errUnsupportedSnapshotFile
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", errUnsupportedSnapshotFile, err)
+	}
+
+	data, err := eurostat.ParseData(zr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, data, nil
+}
+
+// UploadSnapshotHandler is an HTTP handler accepting a snapshot file
+// (multipart form field "file") that is parsed, validated and persisted
+// in the snapshot store with its manifest. Optional form fields:
+// - timestamp - timestamp of the snapshot (default: now); stored snapshots
+// are never replaced, so it has to differ from timestamps of stored ones
+// - load=true - makes the snapshot live
+// - force=true - skips validation
+func (app *Application) UploadSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	sm, ok := app.snapshotManager(w)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, eurostat.DefaultMaxSnapshotSize)
+	err := r.ParseMultipartForm(uploadMemoryLimit)
+	if err != nil {
+		_ = writeJSONError(http.StatusBadRequest, w, fmt.Sprintf("reading multipart form: %s", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	ts := time.Now().UTC().Truncate(time.Second)
+	if v := r.FormValue("timestamp"); v != "" {
+		ts, err = parseTimestampParam(v)
+		if err != nil {
+			_ = writeJSONError(http.StatusBadRequest, w, []map[string]string{{"field": "timestamp", errorMessageKey: failedConversionToTimestampMessage}})
+			return
+		}
+	}
+	load := r.FormValue("load") == "true"
+	force := r.FormValue("force") == "true"
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		_ = writeJSONError(http.StatusBadRequest, w, []map[string]string{{"field": "file", errorMessageKey: paramRequiredUserMessage}})
+		return
+	}
+	defer file.Close()
+
+	raw, data, err := readUploadedSnapshot(file, header.Filename)
+	if err != nil {
+		_ = writeJSONError(http.StatusBadRequest, w, fmt.Sprintf("parsing %s: %s", header.Filename, err))
+		return
+	}
+	snapshot := eurostat.DataSnapshot{Data: data, Timestamp: ts, SourceHash: eurostat.SourceHash(raw)}
+
+	if !force {
+		err = app.validate(snapshot)
+		var verr *eurostat.ValidationError
+		if errors.As(err, &verr) {
+			_ = writeJSONError(http.StatusUnprocessableEntity, w, verr.Failures)
+			return
+		}
+		if err != nil {
+			log.Printf("Validating uploaded snapshot failed: %s\n", err)
+			_ = writeJSONError(http.StatusInternalServerError, w, "internal server error")
+			return
+		}
+	}
+
+	if load {
+		_, pinned, err := app.pinned()
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		if pinned {
+			_ = writeJSONError(http.StatusConflict, w, "a snapshot is pinned; unpin it to load uploaded snapshot")
+			return
+		}
+	}
+
+	manifest := eurostat.NewSnapshotManifest(raw, snapshot, uploadSourcePrefix+header.Filename, nil)
+	stored, err := sm.StoreNewSnapshot(raw, snapshot, manifest)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	log.Printf("Uploaded snapshot %s (%s) persisted: %t.\n", header.Filename, ts, stored)
+
+	res := UploadSnapshotResponse{Manifest: manifest, Stored: stored}
+	if load {
+		// source is unique to the upload, so that it's never coalesced
+		// into a job loading another one
+		source := fmt.Sprintf("%s%s %s %s", uploadSourcePrefix, header.Filename, ts.Format(time.RFC3339), manifest.SHA256)
+		_, err = app.runJob(r.Context(), JobTriggerAdmin, source, func() (UpdateDataResponse, error) {
+			err := app.loadSnapshot(snapshot)
+			res.Loaded = err == nil
+			return UpdateDataResponse{SnapshotTimestamp: ts}, err
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+	}
+
+	_ = writeJSON(http.StatusCreated, w, res)
+}
+
+// validate requires the snapshot to have some data and checks it
+// with the configured validator (if any).
+func (app *Application) validate(snapshot eurostat.DataSnapshot) error {
+	if len(snapshot.Data) == 0 {
+		return &eurostat.ValidationError{Failures: []eurostat.ValidationFailure{
+			{Check: eurostat.CheckMinSeries, Message: ErrEmptySnapshot.Error()},
+		}}
+	}
+	if app.Validator != nil {
+		return app.Validator(snapshot)
+	}
+	return nil
+}
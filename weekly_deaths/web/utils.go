@@ -3,8 +3,12 @@ package web
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
+// timestampParamLayouts lists layouts accepted by timestamp query params.
+var timestampParamLayouts = []string{time.RFC3339, "20060102T150405", "2006-01-02"}
+
 func writeJSON(statusCode int, w http.ResponseWriter, data any) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -25,3 +29,15 @@ func writeJSONError(statusCode int, w http.ResponseWriter, errors any) error {
 	}
 	return nil
 }
+
+func parseTimestampParam(v string) (time.Time, error) {
+	var err error
+	for _, layout := range timestampParamLayouts {
+		var ts time.Time
+		ts, err = time.Parse(layout, v)
+		if err == nil {
+			return ts.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}